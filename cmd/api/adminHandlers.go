@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) listUsersHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Search    string
+		Activated *bool
+		data.Filters
+	}
+	v := validator.New()
+	qs := req.URL.Query()
+	input.Search = app.readString(qs, "q", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "id")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	input.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if input.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.List(input.Search, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"metadata": metadata, "users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// getUserParam loads the user named by the {id} path parameter, answering the request itself when that fails.
+func (app *application) getUserParam(writer http.ResponseWriter, req *http.Request) (*data.User, bool) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return nil, false
+	}
+	usr, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return nil, false
+	}
+	return usr, true
+}
+
+func (app *application) showUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deactivateUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+	if usr.DeactivatedAt != nil {
+		app.errorResponse(writer, req, http.StatusConflict, "user is already deactivated")
+		return
+	}
+
+	now := time.Now()
+	usr.DeactivatedAt = &now
+	err := app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	err = app.models.Tokens.RevokeAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.models.APIKeys.DeleteAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	app.audit(req, "user.deactivate", data.AuditTargetUser, usr.ID, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) reactivateUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+	if usr.DeactivatedAt == nil {
+		app.errorResponse(writer, req, http.StatusConflict, "user is not deactivated")
+		return
+	}
+
+	// NOTE: deactivation never touched Activated, so users who hadn't activated their account still have to.
+	usr.DeactivatedAt = nil
+	err := app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "user.reactivate", data.AuditTargetUser, usr.ID, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// forcePasswordResetHandler locks the user out of their current password and sessions, and emails them a reset token.
+func (app *application) forcePasswordResetHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+
+	usr.Password.Clear()
+	err := app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	err = app.models.Tokens.RevokeAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.models.APIKeys.DeleteAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	app.audit(req, "user.password_reset_forced", data.AuditTargetUser, usr.ID, nil)
+
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.PlainText,
+		}
+		err := app.mailer.Send(usr.Email, "token_password_reset.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	err = app.writeJSON(writer, http.StatusAccepted, envelope{"message": "the user's sessions and API keys were revoked and a password reset email will be sent to them"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) updateUserPermissionsHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Grant  []string `json:"grant"`
+		Revoke []string `json:"revoke"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Grant)+len(input.Revoke) > 0, "grant", "must grant or revoke at least 1 permission")
+	for _, code := range input.Grant {
+		v.Check(all.Include(code), "grant", "must only contain existing permissions")
+	}
+	for _, code := range input.Revoke {
+		v.Check(all.Include(code), "revoke", "must only contain existing permissions")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	if len(input.Grant) > 0 {
+		err = app.models.Permissions.AddForUser(usr.ID, input.Grant...)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		app.audit(req, "user.permissions_grant", data.AuditTargetUser, usr.ID, map[string]any{"permissions": input.Grant})
+	}
+	if len(input.Revoke) > 0 {
+		err = app.models.Permissions.RemoveForUser(usr.ID, input.Revoke...)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		app.audit(req, "user.permissions_revoke", data.AuditTargetUser, usr.ID, map[string]any{"permissions": input.Revoke})
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) unlockUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr, ok := app.getUserParam(writer, req)
+	if !ok {
+		return
+	}
+
+	err := app.models.LoginFailures.Reset(data.UserLoginKey(usr.ID))
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	app.logger.Info("account unlocked", map[string]string{
+		"user_id":  strconv.FormatInt(usr.ID, 10),
+		"admin_id": strconv.FormatInt(app.contextGetUser(req).ID, 10),
+	})
+	app.audit(req, "user.unlock", data.AuditTargetUser, usr.ID, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createAPIKeyHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	granted, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	key, err := data.GenerateAPIKey(usr.ID, input.Name, input.Permissions, input.Expiry)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	v := validator.New()
+	if key.Validate(v, granted); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", key.ID))
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	keys, err := app.models.APIKeys.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+	usr := app.contextGetUser(req)
+	err = app.models.APIKeys.DeleteForUser(id, usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
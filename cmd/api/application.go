@@ -2,13 +2,20 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/PedroDrago/greenlight/internal/data"
 	"github.com/PedroDrago/greenlight/internal/data/jsonlog"
+	"github.com/PedroDrago/greenlight/internal/jwt"
 	"github.com/PedroDrago/greenlight/internal/mailer"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
 )
 
 type config struct {
@@ -21,9 +28,11 @@ type config struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps         float64
+		burst       int
+		enabled     bool
+		apiKeyRPS   float64
+		apiKeyBurst int
 	}
 	smtp struct {
 		host     string
@@ -32,14 +41,53 @@ type config struct {
 		password string
 		sender   string
 	}
+	auth struct {
+		mode       string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+		jwt        struct {
+			keys   []string
+			issuer string
+		}
+	}
+	password struct {
+		hasher            string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+		minEntropy        float64
+		checkBreached     bool
+	}
+	deletionGracePeriod time.Duration
+	maintenance         struct {
+		tokensInterval       time.Duration
+		usersInterval        time.Duration
+		unactivatedRetention time.Duration
+		moviesInterval       time.Duration
+		trashRetention       time.Duration
+		batchSize            int
+	}
+	login struct {
+		maxAttempts   int
+		maxAttemptsIP int
+		lockout       time.Duration
+		maxLockout    time.Duration
+		window        time.Duration
+	}
 }
 
 type application struct {
-	config config
-	models data.Models
-	logger *jsonlog.Logger
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config            config
+	models            data.Models
+	logger            *jsonlog.Logger
+	mailer            mailer.Mailer
+	wg                sync.WaitGroup
+	ipLimiter         *rateLimiter
+	activationLimiter *rateLimiter
+	magicLinkLimiter  *rateLimiter
+	apiKeyLimiter     *rateLimiter
+	jwtKeys           *jwt.KeySet
 }
 
 func parseFlags(cfg *config) {
@@ -52,11 +100,40 @@ func parseFlags(cfg *config) {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.apiKeyRPS, "limiter-api-key-rps", 20, "Rate limiter maximum requests per second for each API key")
+	flag.IntVar(&cfg.limiter.apiKeyBurst, "limiter-api-key-burst", 40, "Rate limiter maximum burst for each API key")
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("TRAPMAIL_USERNAME"), "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("TRAPMAIL_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.pedrodrago.net>", "SMTP sender")
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "token", "Authentication token issued on login (token | jwt)")
+	flag.DurationVar(&cfg.auth.accessTTL, "access-token-ttl", 15*time.Minute, "Access token lifetime")
+	flag.DurationVar(&cfg.auth.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.Func("jwt-keys", "Comma separated paths to PEM private keys (Ed25519 or RSA), the first one signs new tokens", func(val string) error {
+		cfg.auth.jwt.keys = strings.Split(val, ",")
+		return nil
+	})
+	flag.StringVar(&cfg.auth.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer claim")
+	flag.StringVar(&cfg.password.hasher, "password-hasher", "argon2id", "Algorithm used for new password hashes (argon2id | bcrypt)")
+	flag.IntVar(&cfg.password.bcryptCost, "bcrypt-cost", bcrypt.DefaultCost, "bcrypt cost")
+	flag.UintVar(&cfg.password.argon2Memory, "argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.password.argon2Iterations, "argon2-iterations", 3, "argon2id iterations")
+	flag.UintVar(&cfg.password.argon2Parallelism, "argon2-parallelism", 2, "argon2id parallelism")
+	flag.Float64Var(&cfg.password.minEntropy, "password-min-entropy", 40, "Minimum estimated password strength in bits")
+	flag.BoolVar(&cfg.password.checkBreached, "password-check-breached", true, "Reject passwords found in the bundled list of leaked passwords")
+	flag.DurationVar(&cfg.deletionGracePeriod, "deletion-grace-period", 14*24*time.Hour, "How long account deletions can be cancelled")
+	flag.DurationVar(&cfg.maintenance.tokensInterval, "prune-tokens-interval", time.Hour, "How often expired tokens, invitations and stale login failures are deleted (0 disables)")
+	flag.DurationVar(&cfg.maintenance.usersInterval, "prune-users-interval", 24*time.Hour, "How often stale unactivated users are deleted (0 disables)")
+	flag.DurationVar(&cfg.maintenance.unactivatedRetention, "unactivated-retention", 30*24*time.Hour, "How long unactivated users are kept")
+	flag.DurationVar(&cfg.maintenance.moviesInterval, "prune-movies-interval", 24*time.Hour, "How often movies past the trash retention are purged (0 disables)")
+	flag.DurationVar(&cfg.maintenance.trashRetention, "trash-retention", 30*24*time.Hour, "How long deleted movies stay in the trash")
+	flag.IntVar(&cfg.maintenance.batchSize, "prune-batch-size", 1000, "Rows deleted per batch by maintenance tasks")
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins allowed per account before it is locked")
+	flag.IntVar(&cfg.login.maxAttemptsIP, "login-max-attempts-ip", 20, "Failed logins allowed per IP before it is locked")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First lockout duration, doubled on every further failure")
+	flag.DurationVar(&cfg.login.maxLockout, "login-max-lockout", 24*time.Hour, "Maximum lockout duration")
+	flag.DurationVar(&cfg.login.window, "login-window", time.Hour, "How long failed logins are remembered")
 	flag.Parse()
 }
 
@@ -67,6 +144,42 @@ func newApplication() (*application, *sql.DB) {
 		config: cfg,
 		logger: jsonlog.New(os.Stdout, jsonlog.LevelInfo),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		// NOTE: activation emails are limited per address, at most 2 every 10 minutes.
+		activationLimiter: newRateLimiter(rate.Every(5*time.Minute), 2, 10*time.Minute),
+		magicLinkLimiter:  newRateLimiter(rate.Every(time.Minute), 3, 10*time.Minute),
+		apiKeyLimiter:     newRateLimiter(rate.Limit(cfg.limiter.apiKeyRPS), cfg.limiter.apiKeyBurst, 3*time.Minute),
+		ipLimiter:         newRateLimiter(rate.Limit(cfg.limiter.rps), cfg.limiter.burst, 3*time.Minute),
+	}
+	if cfg.auth.mode != "token" && cfg.auth.mode != "jwt" {
+		app.logger.Fatal(fmt.Errorf("invalid auth mode %q", cfg.auth.mode), nil)
+	}
+	if len(cfg.auth.jwt.keys) > 0 {
+		keys, err := jwt.LoadKeySet(cfg.auth.jwt.issuer, cfg.auth.jwt.keys...)
+		if err != nil {
+			app.logger.Fatal(err, nil)
+		}
+		app.jwtKeys = keys
+	}
+	if cfg.auth.mode == "jwt" && app.jwtKeys == nil {
+		app.logger.Fatal(errors.New("auth mode jwt requires at least one key in -jwt-keys"), nil)
+	}
+	switch cfg.password.hasher {
+	case "argon2id":
+		data.DefaultPasswordHasher = data.Argon2idHasher{
+			Memory:      uint32(cfg.password.argon2Memory),
+			Iterations:  uint32(cfg.password.argon2Iterations),
+			Parallelism: uint8(cfg.password.argon2Parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		}
+	case "bcrypt":
+		data.DefaultPasswordHasher = data.BcryptHasher{Cost: cfg.password.bcryptCost}
+	default:
+		app.logger.Fatal(fmt.Errorf("invalid password hasher %q", cfg.password.hasher), nil)
+	}
+	data.DefaultPasswordPolicy = data.PasswordPolicy{
+		MinEntropy:    cfg.password.minEntropy,
+		CheckBreached: cfg.password.checkBreached,
 	}
 	db, err := openDB(cfg)
 	if err != nil {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+// audit records an action taken by the user behind req. The action itself already happened, so a failure to write
+// the event is logged rather than reported to the client.
+func (app *application) audit(req *http.Request, action string, targetType string, targetID int64, details any) {
+	event := &data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		Details:    details,
+	}
+	if targetID != 0 {
+		event.TargetID = &targetID
+	}
+	app.recordAudit(req, event)
+}
+
+// auditChange records an update along with the fields that changed between before and after.
+func (app *application) auditChange(req *http.Request, action string, targetType string, targetID int64, before, after any) {
+	diff, err := data.Diff(before, after)
+	if err != nil {
+		app.logError(req, err)
+		return
+	}
+	app.recordAudit(req, &data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   &targetID,
+		Diff:       diff,
+	})
+}
+
+// recordAudit fills in everything event can learn from req. The actor defaults to the authenticated user, requests
+// that authenticate the user themselves (registrations, logins) set it beforehand.
+func (app *application) recordAudit(req *http.Request, event *data.AuditEvent) {
+	event.IP = app.clientIP(req)
+	event.UserAgent = req.UserAgent()
+	event.RequestID = app.contextGetRequestID(req)
+	if actor := app.contextGetUser(req); event.ActorID == nil && !actor.IsAnonymous() {
+		event.ActorID = &actor.ID
+	}
+	err := app.models.Audit.Insert(event)
+	if err != nil {
+		app.logError(req, err)
+	}
+}
+
+func (app *application) listAuditEventsHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+	v := validator.New()
+	qs := req.URL.Query()
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.Action = app.readString(qs, "action", "")
+	input.Since = app.readTime(qs, "since", v)
+	input.Until = app.readTime(qs, "until", v)
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	input.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.ActorID >= 0, "actor_id", "must not be negative")
+	v.Check(input.Since.IsZero() || input.Until.IsZero() || input.Since.Before(input.Until), "until", "must be after since")
+	if input.Filters.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	events, metadata, err := app.models.Audit.List(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"metadata": metadata, "events": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey       = contextKey("user")
+	apiKeyContextKey     = contextKey("apiKey")
+	requestIDContextKey  = contextKey("requestID")
+	membershipContextKey = contextKey("membership")
+)
+
+func (app *application) contextSetUser(req *http.Request, usr *data.User) *http.Request {
+	ctx := context.WithValue(req.Context(), userContextKey, usr)
+	return req.WithContext(ctx)
+}
+
+func (app *application) contextGetUser(req *http.Request) *data.User {
+	usr, ok := req.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return usr
+}
+
+func (app *application) contextSetAPIKey(req *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(req.Context(), apiKeyContextKey, key)
+	return req.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or nil for every other kind of request.
+func (app *application) contextGetAPIKey(req *http.Request) *data.APIKey {
+	key, _ := req.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetRequestID(req *http.Request, id string) *http.Request {
+	ctx := context.WithValue(req.Context(), requestIDContextKey, id)
+	return req.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetMembership(req *http.Request, membership *data.Membership) *http.Request {
+	ctx := context.WithValue(req.Context(), membershipContextKey, membership)
+	return req.WithContext(ctx)
+}
+
+// contextGetMembership returns the caller's membership in the organization the request is scoped to. Only handlers
+// behind requireOrganization can use it.
+func (app *application) contextGetMembership(req *http.Request) *data.Membership {
+	membership, ok := req.Context().Value(membershipContextKey).(*data.Membership)
+	if !ok {
+		panic("missing membership value in request context")
+	}
+	return membership
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) failedValidationResponse(writer http.ResponseWriter, req *http.Request, errors map[string]string) {
@@ -13,6 +16,7 @@ func (app *application) logError(req *http.Request, err error) {
 	app.logger.Error(err, map[string]string{
 		"request_method": req.Method,
 		"request_url":    req.URL.String(),
+		"request_id":     app.contextGetRequestID(req),
 	})
 }
 
@@ -54,3 +58,66 @@ func (app *application) rateLimitExceededResponse(writer http.ResponseWriter, re
 	message := "rate limit exceeded"
 	app.errorResponse(writer, req, http.StatusTooManyRequests, message)
 }
+
+func (app *application) invalidCredentialsResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(writer, req, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	app.errorResponse(writer, req, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAPIKeyResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "invalid or expired API key"
+	app.errorResponse(writer, req, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(writer, req, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(writer, req, http.StatusForbidden, message)
+}
+
+func (app *application) deactivatedAccountResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "your user account has been deactivated, please contact support"
+	app.errorResponse(writer, req, http.StatusForbidden, message)
+}
+
+func (app *application) deletionScheduledResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "your user account is scheduled for deletion, use the link we emailed you to cancel it"
+	app.errorResponse(writer, req, http.StatusForbidden, message)
+}
+
+func (app *application) organizationRequiredResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "you belong to several organizations, pick one with the X-Organization header"
+	app.errorResponse(writer, req, http.StatusBadRequest, message)
+}
+
+func (app *application) invitationEmailMismatchResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "this invitation was sent to a different email address"
+	app.errorResponse(writer, req, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(writer, req, http.StatusForbidden, message)
+}
+
+func (app *application) twoFactorRequiredResponse(writer http.ResponseWriter, req *http.Request) {
+	message := "a two-factor authentication code is required"
+	app.errorResponse(writer, req, http.StatusUnauthorized, message)
+}
+
+func (app *application) loginLockedResponse(writer http.ResponseWriter, req *http.Request, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	writer.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(writer, req, http.StatusTooManyRequests, message)
+}
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PedroDrago/greenlight/internal/validator"
 )
@@ -80,6 +82,14 @@ func (app *application) getIdParam(req *http.Request) (int64, error) {
 	return int64(id), nil
 }
 
+func (app *application) clientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 	if s == "" {
@@ -110,6 +120,34 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return n
 }
 
+// readBool returns nil when key is missing, so callers can tell "not filtered" apart from false.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+	return &b
+}
+
+// readTime parses an RFC 3339 timestamp, returning the zero time when key is missing.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+	return t
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
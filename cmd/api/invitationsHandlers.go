@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createInvitationHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidateRole(v, input.Role)
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	membership := app.contextGetMembership(req)
+	invitee, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		_, err = app.models.Memberships.Get(membership.OrganizationID, invitee.ID)
+		switch {
+		case err == nil:
+			v.AddError("email", "this user is already a member of the organization")
+			app.failedValidationResponse(writer, req, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	invitation, err := app.models.Invitations.New(membership.OrganizationID, input.Email, input.Role, usr.ID, 7*24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	org, err := app.models.Organizations.Get(membership.OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	app.audit(req, "organization.invitation_create", data.AuditTargetOrganization, membership.OrganizationID, invitation)
+
+	app.background(func() {
+		data := map[string]any{
+			"invitationToken":  invitation.PlainText,
+			"organizationName": org.Name,
+			"inviterName":      usr.Name,
+			"role":             invitation.Role,
+		}
+		err := app.mailer.Send(invitation.Email, "org_invitation.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listInvitationsHandler(writer http.ResponseWriter, req *http.Request) {
+	invitations, err := app.models.Invitations.GetAllForOrganization(app.contextGetMembership(req).OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteInvitationHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+
+	orgID := app.contextGetMembership(req).OrganizationID
+	err = app.models.Invitations.Delete(orgID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "organization.invitation_revoke", data.AuditTargetOrganization, orgID, map[string]any{"invitation_id": id})
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// acceptInvitationHandler adds the invited email address to the organization, creating the account first when
+// there is none yet (name and password are only needed then). Receiving the invitation proves the address is theirs,
+// so a new account starts out activated, but an existing one must have been activated already. Members keep their
+// current role. Signed in users can only accept invitations sent to their address.
+func (app *application) acceptInvitationHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+		Name           string `json:"name"`
+		Password       string `json:"password"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	invitation, err := app.models.Invitations.GetForToken(input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if actor := app.contextGetUser(req); !actor.IsAnonymous() && !strings.EqualFold(actor.Email, invitation.Email) {
+		app.invitationEmailMismatchResponse(writer, req)
+		return
+	}
+
+	usr, err := app.models.Users.GetByEmail(invitation.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if usr == nil {
+		usr = &data.User{
+			Name:      input.Name,
+			Email:     invitation.Email,
+			Activated: true,
+		}
+		err = usr.Password.Set(input.Password)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		if usr.Validate(v); !v.Valid() {
+			app.failedValidationResponse(writer, req, v.Errors)
+			return
+		}
+	} else if !usr.Activated {
+		// NOTE: anybody can register an address they don't own, so an account that was never activated may well have
+		// been set up by someone else. Its owner has to go through the activation email first.
+		app.inactiveAccountResponse(writer, req)
+		return
+	} else if usr.DeactivatedAt != nil {
+		app.deactivatedAccountResponse(writer, req)
+		return
+	} else if usr.DeletionScheduledAt != nil {
+		app.deletionScheduledResponse(writer, req)
+		return
+	}
+
+	registered := usr.ID == 0
+	membership, err := app.models.Invitations.Accept(invitation, usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if registered {
+		app.recordAudit(req, &data.AuditEvent{ActorID: &usr.ID, Action: "user.register", TargetType: data.AuditTargetUser, TargetID: &usr.ID})
+	}
+	app.recordAudit(req, &data.AuditEvent{
+		ActorID:    &usr.ID,
+		Action:     "organization.invitation_accept",
+		TargetType: data.AuditTargetOrganization,
+		TargetID:   &invitation.OrganizationID,
+		Details:    map[string]any{"invitation_id": invitation.ID, "role": membership.Role},
+	})
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr, "member": membership}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
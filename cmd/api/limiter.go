@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter keeps one token bucket per key (an IP, an email address...) and forgets keys that haven't been seen for ttl.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	ttl     time.Duration
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(limit rate.Limit, burst int, ttl time.Duration) *rateLimiter {
+	l := &rateLimiter{
+		limit:   limit,
+		burst:   burst,
+		ttl:     ttl,
+		clients: make(map[string]*client),
+	}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > l.ttl {
+					delete(l.clients, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+	return l
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, found := l.clients[key]; !found {
+		l.clients[key] = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+	}
+	l.clients[key].lastSeen = time.Now()
+	return l.clients[key].limiter.Allow()
+}
+
+// exhausted reports whether key is over its limit, without taking a token.
+func (l *rateLimiter) exhausted(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	client, found := l.clients[key]
+	if !found {
+		return false
+	}
+	return client.limiter.Tokens() < 1
+}
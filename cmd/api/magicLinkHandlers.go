@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createMagicLinkTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	if app.config.limiter.enabled && !app.magicLinkLimiter.allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(writer, req)
+		return
+	}
+
+	// NOTE: same as password resets, the response never tells whether the account exists.
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it containing a sign-in token"}
+
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	if usr != nil && usr.Activated && usr.DeactivatedAt == nil && usr.DeletionScheduledAt == nil {
+		token, err := app.models.Tokens.New(usr.ID, 15*time.Minute, data.ScopeMagicLink)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]any{
+				"magicLinkToken": token.PlainText,
+			}
+			err := app.mailer.Send(usr.Email, "token_magic_link.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(writer, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// exchangeMagicLinkTokenHandler signs the user in with the token we emailed them. Two-factor authentication still
+// applies, and the token is only used up once the second factor checked out, so the client can retry with a code.
+func (app *application) exchangeMagicLinkTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+		TOTPCode       string `json:"totp_code"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	lockedUntil, err := app.models.LoginFailures.LockedUntil(data.IPLoginKey(app.clientIP(req)), data.UserLoginKey(usr.ID))
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.loginLockedResponse(writer, req, lockedUntil)
+		return
+	}
+	if usr.DeactivatedAt != nil {
+		app.deactivatedAccountResponse(writer, req)
+		return
+	}
+	if usr.DeletionScheduledAt != nil {
+		app.deletionScheduledResponse(writer, req)
+		return
+	}
+	if !app.verifySecondFactor(writer, req, usr, input.TOTPCode) {
+		return
+	}
+
+	err = app.models.Tokens.Consume(data.ScopeMagicLink, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	app.startSession(writer, req, usr, "magic_link")
+}
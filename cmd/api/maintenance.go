@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// startMaintenance runs the periodic cleanup jobs until stop is closed. Jobs are tracked by app.wg, so serve() waits
+// for a running batch to finish before the process exits.
+func (app *application) startMaintenance(stop <-chan struct{}) {
+	cfg := app.config.maintenance
+	app.runPeriodically("prune expired tokens", cfg.tokensInterval, stop, func() (int64, error) {
+		return app.models.Tokens.DeleteExpired(cfg.batchSize)
+	})
+	app.runPeriodically("prune expired invitations", cfg.tokensInterval, stop, func() (int64, error) {
+		return app.models.Invitations.DeleteExpired(cfg.batchSize)
+	})
+	app.runPeriodically("prune stale login failures", cfg.tokensInterval, stop, func() (int64, error) {
+		return app.models.LoginFailures.DeleteStale(app.config.login.window, cfg.batchSize)
+	})
+	app.runPeriodically("purge unactivated users", cfg.usersInterval, stop, func() (int64, error) {
+		return app.models.Users.DeleteUnactivated(cfg.unactivatedRetention, cfg.batchSize)
+	})
+	app.runPeriodically("delete scheduled users", cfg.usersInterval, stop, func() (int64, error) {
+		return app.models.Users.DeleteScheduled(cfg.batchSize)
+	})
+	app.runPeriodically("purge trashed movies", cfg.moviesInterval, stop, func() (int64, error) {
+		return app.models.Movies.DeleteTrashed(cfg.trashRetention, cfg.batchSize)
+	})
+}
+
+// runPeriodically calls job every interval, logging how many rows it removed. An interval of 0 disables the job.
+func (app *application) runPeriodically(name string, interval time.Duration, stop <-chan struct{}, job func() (int64, error)) {
+	if interval <= 0 {
+		return
+	}
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				app.runJob(name, job)
+			}
+		}
+	}()
+}
+
+func (app *application) runJob(name string, job func() (int64, error)) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Errorf("%s", err), map[string]string{"task": name})
+		}
+	}()
+	start := time.Now()
+	deleted, err := job()
+	if err != nil {
+		app.logger.Error(err, map[string]string{"task": name})
+		return
+	}
+	app.logger.Info("maintenance task completed", map[string]string{
+		"task":     name,
+		"deleted":  strconv.FormatInt(deleted, 10),
+		"duration": time.Since(start).String(),
+	})
+}
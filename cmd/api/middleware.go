@@ -1,15 +1,42 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"sync"
-	"time"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
-	"golang.org/x/time/rate"
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
 )
 
+// requestID tags every request with an ID, reusing the one set by a proxy in front of us when it looks sane. It is
+// echoed back in X-Request-ID and ends up in logs and audit events.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if len(id) == 0 || len(id) > 128 || !validator.Matches(id, requestIDRX) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(writer, req, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+		writer.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(writer, app.contextSetRequestID(req, id))
+	})
+}
+
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
 		defer func() {
@@ -23,26 +50,6 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 }
 
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
 	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
 		if app.config.limiter.enabled {
 			ip, _, err := net.SplitHostPort(req.RemoteAddr)
@@ -50,19 +57,278 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				app.serverErrorResponse(writer, req, err)
 				return
 			}
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-			}
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			// NOTE: known API keys get their own budget, see authenticateAPIKey. Keys that can't be found are charged
+			// to the IP there, so made up keys can't be used to hammer the database.
+			if req.Header.Get("X-API-Key") != "" {
+				if app.ipLimiter.exhausted(ip) {
+					app.rateLimitExceededResponse(writer, req)
+					return
+				}
+			} else if !app.ipLimiter.allow(ip) {
 				app.rateLimitExceededResponse(writer, req)
 				return
 			}
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(writer, req)
 	})
 }
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Add("Vary", "Authorization")
+		writer.Header().Add("Vary", "X-API-Key")
+		if key := req.Header.Get("X-API-Key"); key != "" {
+			app.authenticateAPIKey(writer, req, next, key)
+			return
+		}
+
+		authorizationHeader := req.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			req = app.contextSetUser(req, data.AnonymousUser)
+			next.ServeHTTP(writer, req)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(writer, req)
+			return
+		}
+		token := headerParts[1]
+
+		if app.jwtKeys != nil && strings.Count(token, ".") == 2 {
+			app.authenticateJWT(writer, req, next, token)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(writer, req)
+			return
+		}
+
+		usr, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(writer, req)
+			default:
+				app.serverErrorResponse(writer, req, err)
+			}
+			return
+		}
+		err = app.models.Tokens.Touch(token)
+		if err != nil {
+			app.logError(req, err)
+		}
+
+		req = app.contextSetUser(req, usr)
+		next.ServeHTTP(writer, req)
+	})
+}
+
+func (app *application) authenticateJWT(writer http.ResponseWriter, req *http.Request, next http.Handler, token string) {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(writer, req)
+		return
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(writer, req)
+		return
+	}
+	// NOTE: the claims are enough for the gateway, but we still load the user so handlers always see the current record.
+	usr, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	req = app.contextSetUser(req, usr)
+	next.ServeHTTP(writer, req)
+}
+
+func (app *application) authenticateAPIKey(writer http.ResponseWriter, req *http.Request, next http.Handler, plaintext string) {
+	key, err := app.models.APIKeys.GetForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if app.config.limiter.enabled {
+				app.ipLimiter.allow(app.clientIP(req))
+			}
+			app.invalidAPIKeyResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if app.config.limiter.enabled && !app.apiKeyLimiter.allow(strconv.FormatInt(key.ID, 10)) {
+		app.rateLimitExceededResponse(writer, req)
+		return
+	}
+
+	usr, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.models.APIKeys.Touch(key.ID)
+	if err != nil {
+		app.logError(req, err)
+	}
+
+	req = app.contextSetUser(req, usr)
+	req = app.contextSetAPIKey(req, key)
+	next.ServeHTTP(writer, req)
+}
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		usr := app.contextGetUser(req)
+		if usr.IsAnonymous() {
+			app.authenticationRequiredResponse(writer, req)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		usr := app.contextGetUser(req)
+		if !usr.Activated {
+			app.inactiveAccountResponse(writer, req)
+			return
+		}
+		// NOTE: JWTs can't be revoked, so they are still around after an administrator deactivated the account.
+		if usr.DeactivatedAt != nil {
+			app.deactivatedAccountResponse(writer, req)
+			return
+		}
+		// NOTE: API keys and JWTs outlive the session revocation done when the deletion was requested.
+		if usr.DeletionScheduledAt != nil {
+			app.deletionScheduledResponse(writer, req)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	})
+	return app.requireAuthenticatedUser(fn)
+}
+
+// requireSessionUser keeps API keys out of account management. A key only carries the permissions it was created
+// with, and routes that don't check one of them (changing the email or password, two-factor settings, sessions,
+// organization membership...) must only be reachable by the user themself.
+func (app *application) requireSessionUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if app.contextGetAPIKey(req) != nil {
+			app.notPermittedResponse(writer, req)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	}
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(writer http.ResponseWriter, req *http.Request) {
+		ok, err := app.hasPermission(req, code)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		if !ok {
+			app.notPermittedResponse(writer, req)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	}
+	return app.requireActivatedUser(fn)
+}
+
+// hasPermission reports whether the user behind req holds code. Requests made with an API key are further limited
+// to the permissions of that key.
+func (app *application) hasPermission(req *http.Request, code string) (bool, error) {
+	usr := app.contextGetUser(req)
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		return false, err
+	}
+	if !permissions.Include(code) {
+		return false, nil
+	}
+	if key := app.contextGetAPIKey(req); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// requireOrganization scopes the request to an organization the user is a member of. It is picked by the {org} path
+// prefix, then the X-Organization header, and can be left out by users who belong to a single organization.
+// Organizations the user isn't a member of are reported as missing, so their slugs can't be probed.
+func (app *application) requireOrganization(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Add("Vary", "X-Organization")
+		usr := app.contextGetUser(req)
+
+		slug := req.PathValue("org")
+		if slug == "" {
+			slug = req.Header.Get("X-Organization")
+		}
+
+		var org *data.Organization
+		if slug == "" {
+			orgs, err := app.models.Organizations.GetAllForUser(usr.ID)
+			if err != nil {
+				app.serverErrorResponse(writer, req, err)
+				return
+			}
+			if len(orgs) != 1 {
+				app.organizationRequiredResponse(writer, req)
+				return
+			}
+			org = orgs[0]
+		} else {
+			var err error
+			org, err = app.models.Organizations.GetBySlug(slug)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(writer, req)
+				default:
+					app.serverErrorResponse(writer, req, err)
+				}
+				return
+			}
+		}
+
+		membership, err := app.models.Memberships.Get(org.ID, usr.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(writer, req)
+			default:
+				app.serverErrorResponse(writer, req, err)
+			}
+			return
+		}
+		next.ServeHTTP(writer, app.contextSetMembership(req, membership))
+	}
+}
+
+// requireOrganizationRole is requireOrganization for members holding one of roles.
+func (app *application) requireOrganizationRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	fn := func(writer http.ResponseWriter, req *http.Request) {
+		if !slices.Contains(roles, app.contextGetMembership(req).Role) {
+			app.notPermittedResponse(writer, req)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	}
+	return app.requireOrganization(fn)
+}
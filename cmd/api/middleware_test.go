@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/data/jsonlog"
+	"golang.org/x/time/rate"
+)
+
+func TestRequireSessionUser(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+	usr := &data.User{ID: 1, Email: "alice@example.com", Activated: true}
+	key := &data.APIKey{ID: 1, UserID: usr.ID, Permissions: data.Permissions{"movies:read", "movies:write"}}
+
+	called := false
+	handler := app.requireSessionUser(app.requireActivatedUser(func(writer http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+
+	tests := []struct {
+		name   string
+		user   *data.User
+		key    *data.APIKey
+		status int
+		called bool
+	}{
+		{"session", usr, nil, http.StatusOK, true},
+		{"api key", usr, key, http.StatusForbidden, false},
+		{"anonymous", data.AnonymousUser, nil, http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(http.MethodPatch, "/v1/users/me/email", nil)
+			req = app.contextSetUser(req, tt.user)
+			if tt.key != nil {
+				req = app.contextSetAPIKey(req, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("got status %d, want %d", rr.Code, tt.status)
+			}
+			if called != tt.called {
+				t.Errorf("handler called = %v, want %v", called, tt.called)
+			}
+		})
+	}
+}
+
+// TestRequireSessionUserRoutes goes through the whole stack with a real API key holding every permission of its
+// user, and checks the account and key management routes refuse it.
+func TestRequireSessionUserRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	usr, token := newTestUser(t, app, "movies:read", "movies:write", "apikeys:write")
+
+	key, err := data.GenerateAPIKey(usr.ID, "ci", data.Permissions{"movies:read", "movies:write", "apikeys:write"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPatch, "/v1/users/me/email", map[string]string{"email": "attacker-" + randomName(t) + "@example.com"}},
+		{http.MethodPatch, "/v1/users/me", map[string]string{"name": "Mallory"}},
+		{http.MethodGet, "/v1/users/me", nil},
+		{http.MethodGet, "/v1/users/me/export", nil},
+		{http.MethodDelete, "/v1/users/me", map[string]string{"password": testPassword}},
+		{http.MethodPost, "/v1/users/me/totp", nil},
+		{http.MethodGet, "/v1/users/me/tokens", nil},
+		{http.MethodDelete, "/v1/users/me/tokens", nil},
+		{http.MethodGet, "/v1/orgs", nil},
+		{http.MethodPost, "/v1/orgs", map[string]string{"name": "Evil", "slug": "evil-" + randomName(t)}},
+		{http.MethodGet, "/v1/api-keys", nil},
+		{http.MethodPost, "/v1/api-keys", map[string]any{"name": "forever", "permissions": []string{"movies:read"}}},
+		{http.MethodDelete, fmt.Sprintf("/v1/api-keys/%d", key.ID), nil},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			rr := request(t, app, route.method, route.path, route.body, "X-API-Key", key.PlainText)
+			checkStatus(t, rr, http.StatusForbidden)
+		})
+	}
+
+	// The key still works where it was meant to, and the user's own session is unaffected.
+	rr := request(t, app, http.MethodGet, "/v1/movies", nil, "X-API-Key", key.PlainText)
+	checkStatus(t, rr, http.StatusOK)
+	rr = request(t, app, http.MethodGet, "/v1/users/me", nil, bearer(token)...)
+	checkStatus(t, rr, http.StatusOK)
+}
+
+func TestRateLimitAPIKeys(t *testing.T) {
+	app := &application{
+		logger:    jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		ipLimiter: newRateLimiter(rate.Every(time.Hour), 2, time.Minute),
+	}
+	app.config.limiter.enabled = true
+
+	// unknownKey stands in for authenticateAPIKey turning down a key it couldn't find, knownKey for one it found.
+	unknownKey := app.rateLimit(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		app.ipLimiter.allow(app.clientIP(req))
+	}))
+	knownKey := app.rateLimit(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {}))
+
+	send := func(handler http.Handler, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", "gl_made_up")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := range 2 {
+		if code := send(unknownKey, "192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if code := send(unknownKey, "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("unknown keys must be charged to the IP: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	for i := range 10 {
+		if code := send(knownKey, "192.0.2.2"); code != http.StatusOK {
+			t.Fatalf("request %d: known keys must not use up the IP budget: got status %d", i, code)
+		}
+	}
+}
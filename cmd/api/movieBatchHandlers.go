@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+const maxBatchOperations = 1000
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// batchMoviesHandler applies many creates, updates and deletes in one request, answering with a result per
+// operation. With "atomic" set nothing is saved unless every operation succeeds, otherwise each operation stands on
+// its own.
+func (app *application) batchMoviesHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Atomic     bool `json:"atomic"`
+		Operations []struct {
+			Op      string        `json:"op"`
+			ID      int64         `json:"id"`
+			Version *int32        `json:"version"`
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		} `json:"operations"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	orgID := app.contextGetMembership(req).OrganizationID
+	var ids []int64
+	for _, item := range input.Operations {
+		if item.Op == data.MovieOpUpdate || item.Op == data.MovieOpDelete {
+			ids = append(ids, item.ID)
+		}
+	}
+	existing, err := app.models.Movies.GetMany(orgID, ids)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	// NOTE: same rules as authorizeMovieWrite, with movies:admin looked up once for the whole batch.
+	usr := app.contextGetUser(req)
+	movieAdmin, err := app.hasPermission(req, "movies:admin")
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	orgOwner := app.contextGetMembership(req).Role == data.RoleOwner
+
+	results := make([]*batchResult, len(input.Operations))
+	ops := make([]*data.MovieOp, len(input.Operations))
+	seen := make(map[int64]bool, len(ids))
+	for i, item := range input.Operations {
+		results[i] = &batchResult{Index: i, Op: item.Op}
+		v := validator.New()
+		v.Check(validator.PermittedValue(item.Op, data.MovieOpCreate, data.MovieOpUpdate, data.MovieOpDelete), "op", "must be create, update or delete")
+		if !v.Valid() {
+			results[i].Status, results[i].Error = http.StatusUnprocessableEntity, v.Errors
+			continue
+		}
+
+		if item.Op == data.MovieOpCreate {
+			movie := &data.Movie{OrganizationID: orgID, CreatedBy: usr.ID}
+			applyMovieInput(movie, item.Title, item.Year, item.Runtime, item.Genres)
+			if movie.Validate(v); !v.Valid() {
+				results[i].Status, results[i].Error = http.StatusUnprocessableEntity, v.Errors
+				continue
+			}
+			ops[i] = &data.MovieOp{Op: item.Op, Movie: movie}
+			continue
+		}
+
+		v.Check(!seen[item.ID], "id", "must not appear in more than one operation")
+		seen[item.ID] = true
+		if !v.Valid() {
+			results[i].Status, results[i].Error = http.StatusUnprocessableEntity, v.Errors
+			continue
+		}
+		current, ok := existing[item.ID]
+		if !ok {
+			results[i].Status, results[i].Error = http.StatusNotFound, "Resource could not be found"
+			continue
+		}
+		if item.Version != nil && *item.Version != current.Version {
+			results[i].Status, results[i].Error = http.StatusConflict, "unable to update the record due to an edit conflict, please try again"
+			continue
+		}
+		if current.CreatedBy != usr.ID && !orgOwner && !movieAdmin {
+			results[i].Status, results[i].Error = http.StatusForbidden, "your user account doesn't have the necessary permissions to access this resource"
+			continue
+		}
+
+		movie := *current
+		if item.Op == data.MovieOpUpdate {
+			applyMovieInput(&movie, item.Title, item.Year, item.Runtime, item.Genres)
+			if movie.Validate(v); !v.Valid() {
+				results[i].Status, results[i].Error = http.StatusUnprocessableEntity, v.Errors
+				continue
+			}
+		}
+		ops[i] = &data.MovieOp{Op: item.Op, Movie: &movie}
+	}
+
+	var valid []*data.MovieOp
+	for _, op := range ops {
+		if op != nil {
+			valid = append(valid, op)
+		}
+	}
+	committed := false
+	if !input.Atomic || len(valid) == len(ops) {
+		committed, err = app.models.Movies.ApplyBatch(orgID, usr.ID, valid, input.Atomic)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	for i, op := range ops {
+		switch {
+		case op == nil:
+		case errors.Is(op.Err, data.ErrRecordNotFound):
+			results[i].Status, results[i].Error = http.StatusNotFound, "Resource could not be found"
+		case errors.Is(op.Err, data.ErrEditConflict):
+			results[i].Status, results[i].Error = http.StatusConflict, "unable to update the record due to an edit conflict, please try again"
+		case op.Err != nil:
+			app.logError(req, op.Err)
+			results[i].Status, results[i].Error = http.StatusInternalServerError, "Internal Server Error"
+		case !committed:
+			results[i].Status, results[i].Error = http.StatusFailedDependency, "not applied because another operation of the batch failed"
+		case op.Op == data.MovieOpCreate:
+			results[i].Status, results[i].Movie = http.StatusCreated, op.Movie
+			app.audit(req, "movie.create", data.AuditTargetMovie, op.Movie.ID, op.Movie)
+		case op.Op == data.MovieOpUpdate:
+			results[i].Status, results[i].Movie = http.StatusOK, op.Movie
+			app.auditChange(req, "movie.update", data.AuditTargetMovie, op.Movie.ID, existing[op.Movie.ID], op.Movie)
+		default:
+			results[i].Status, results[i].Movie = http.StatusOK, op.Movie
+			app.audit(req, "movie.delete", data.AuditTargetMovie, op.Movie.ID, nil)
+		}
+		// NOTE: an atomic batch answers with the status of its first real failure.
+		if input.Atomic && status == http.StatusOK && results[i].Status >= 400 && results[i].Status != http.StatusFailedDependency {
+			status = results[i].Status
+		}
+	}
+
+	err = app.writeJSON(writer, status, envelope{"committed": committed, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
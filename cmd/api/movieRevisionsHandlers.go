@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+)
+
+// getMovieParam loads the movie named by the {id} path parameter from the organization of the request, answering
+// the request itself when that fails.
+func (app *application) getMovieParam(writer http.ResponseWriter, req *http.Request) (*data.Movie, bool) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return nil, false
+	}
+	movie, err := app.models.Movies.Get(app.contextGetMembership(req).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return nil, false
+	}
+	return movie, true
+}
+
+// getRevisionParam loads the revision of movie named by the {version} path parameter, answering the request itself
+// when that fails.
+func (app *application) getRevisionParam(writer http.ResponseWriter, req *http.Request, movie *data.Movie) (*data.MovieRevision, bool) {
+	version, err := strconv.ParseInt(req.PathValue("version"), 10, 32)
+	if err != nil || version < 1 {
+		app.notFoundResponse(writer, req)
+		return nil, false
+	}
+	rev, err := app.models.Revisions.Get(movie.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return nil, false
+	}
+	return rev, true
+}
+
+func (app *application) listMovieRevisionsHandler(writer http.ResponseWriter, req *http.Request) {
+	movie, ok := app.getMovieParam(writer, req)
+	if !ok {
+		return
+	}
+	revisions, err := app.models.Revisions.GetAllForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// showMovieRevisionHandler returns a revision along with what changed between it and the current version.
+func (app *application) showMovieRevisionHandler(writer http.ResponseWriter, req *http.Request) {
+	movie, ok := app.getMovieParam(writer, req)
+	if !ok {
+		return
+	}
+	rev, ok := app.getRevisionParam(writer, req, movie)
+	if !ok {
+		return
+	}
+
+	old := *movie
+	rev.Apply(&old)
+	old.CreatedBy = rev.CreatedBy
+	old.Version = rev.Version
+	diff, err := data.Diff(old, movie)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"revision": rev, "diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// restoreMovieRevisionHandler brings back the content of a revision as a new version, so the restore itself shows
+// up in the history. Clients can send X-Expected-Version to make sure they are not overwriting a newer edit.
+func (app *application) restoreMovieRevisionHandler(writer http.ResponseWriter, req *http.Request) {
+	movie, ok := app.getMovieParam(writer, req)
+	if !ok {
+		return
+	}
+	if !app.authorizeMovieWrite(writer, req, movie) {
+		return
+	}
+	if expected := req.Header.Get("X-Expected-Version"); expected != "" {
+		if strconv.Itoa(int(movie.Version)) != expected {
+			app.editConflictResponse(writer, req)
+			return
+		}
+	}
+	rev, ok := app.getRevisionParam(writer, req, movie)
+	if !ok {
+		return
+	}
+
+	before := *movie
+	rev.Apply(movie)
+	err := app.models.Movies.Update(movie, app.contextGetUser(req).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.auditChange(req, "movie.revision_restore", data.AuditTargetMovie, movie.ID, before, movie)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
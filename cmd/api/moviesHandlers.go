@@ -23,10 +23,12 @@ func (app *application) createMovieHandler(writer http.ResponseWriter, req *http
 	}
 	v := validator.New()
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		OrganizationID: app.contextGetMembership(req).OrganizationID,
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
+		CreatedBy:      app.contextGetUser(req).ID,
 	}
 	if movie.Validate(v); !v.Valid() {
 		app.failedValidationResponse(writer, req, v.Errors)
@@ -37,6 +39,8 @@ func (app *application) createMovieHandler(writer http.ResponseWriter, req *http
 		app.serverErrorResponse(writer, req, err)
 		return
 	}
+	app.audit(req, "movie.create", data.AuditTargetMovie, movie.ID, movie)
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 	err = app.writeJSON(writer, http.StatusCreated, envelope{"movie": movie}, headers)
@@ -51,7 +55,7 @@ func (app *application) showMovieHandler(writer http.ResponseWriter, req *http.R
 		app.notFoundResponse(writer, req)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(app.contextGetMembership(req).OrganizationID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -73,7 +77,7 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, req *http
 		app.notFoundResponse(writer, req)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(app.contextGetMembership(req).OrganizationID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -83,6 +87,10 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, req *http
 		}
 		return
 	}
+	if !app.authorizeMovieWrite(writer, req, movie) {
+		return
+	}
+	before := *movie
 
 	var input struct {
 		Title   *string       `json:"title"`
@@ -96,27 +104,221 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, req *http
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+	applyMovieInput(movie, input.Title, input.Year, input.Runtime, input.Genres)
+
+	v := validator.New()
+
+	if movie.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(movie, app.contextGetUser(req).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.auditChange(req, "movie.update", data.AuditTargetMovie, movie.ID, before, movie)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteMovieHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(app.contextGetMembership(req).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if !app.authorizeMovieWrite(writer, req, movie) {
+		return
+	}
+
+	err = app.models.Movies.Delete(app.contextGetMembership(req).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "movie.delete", data.AuditTargetMovie, id, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "Movie moved to the trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
 	}
-	if input.Year != nil {
-		movie.Year = *input.Year
+}
+
+// applyMovieInput copies the fields that were provided onto movie.
+func applyMovieInput(movie *data.Movie, title *string, year *int32, runtime *data.Runtime, genres []string) {
+	if title != nil {
+		movie.Title = *title
 	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	if year != nil {
+		movie.Year = *year
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	if runtime != nil {
+		movie.Runtime = *runtime
+	}
+	if genres != nil {
+		movie.Genres = genres
+	}
+}
+
+// authorizeMovieWrite lets the owner of movie, owners of its organization and holders of movies:admin change it,
+// answering the request itself for everybody else.
+func (app *application) authorizeMovieWrite(writer http.ResponseWriter, req *http.Request, movie *data.Movie) bool {
+	if app.contextGetUser(req).ID == movie.CreatedBy || app.contextGetMembership(req).Role == data.RoleOwner {
+		return true
+	}
+	ok, err := app.hasPermission(req, "movies:admin")
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return false
+	}
+	if !ok {
+		app.notPermittedResponse(writer, req)
+		return false
+	}
+	return true
+}
+
+func (app *application) transferMovieHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+	movie, err := app.models.Movies.Get(app.contextGetMembership(req).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+	err = app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
 	}
 
 	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	owner, err := app.models.Memberships.Get(movie.OrganizationID, input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "must be a member of the movie's organization")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
 
-	if movie.Validate(v); !v.Valid() {
+	before := *movie
+	movie.CreatedBy = owner.UserID
+	err = app.models.Movies.Update(movie, app.contextGetUser(req).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.auditChange(req, "movie.transfer", data.AuditTargetMovie, movie.ID, before, movie)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listTrashHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := req.URL.Query()
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-deleted_at")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	input.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if input.Validate(v); !v.Valid() {
 		app.failedValidationResponse(writer, req, v.Errors)
 		return
 	}
+	movies, metadata, err := app.models.Movies.ListDeleted(app.contextGetMembership(req).OrganizationID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+	movie, err := app.models.Movies.GetDeleted(app.contextGetMembership(req).OrganizationID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if !app.authorizeMovieWrite(writer, req, movie) {
+		return
+	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Restore(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -126,6 +328,7 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, req *http
 		}
 		return
 	}
+	app.audit(req, "movie.restore", data.AuditTargetMovie, movie.ID, nil)
 
 	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -133,14 +336,15 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, req *http
 	}
 }
 
-func (app *application) deleteMovieHandler(writer http.ResponseWriter, req *http.Request) {
+// purgeMovieHandler permanently deletes a movie, which has to be in the trash already.
+func (app *application) purgeMovieHandler(writer http.ResponseWriter, req *http.Request) {
 	id, err := app.getIdParam(req)
 	if err != nil {
 		app.notFoundResponse(writer, req)
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Purge(app.contextGetMembership(req).OrganizationID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -150,7 +354,9 @@ func (app *application) deleteMovieHandler(writer http.ResponseWriter, req *http
 		}
 		return
 	}
-	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "Movie successfully deleted"}, nil)
+	app.audit(req, "movie.purge", data.AuditTargetMovie, id, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "Movie permanently deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, req, err)
 	}
@@ -166,14 +372,22 @@ type params struct {
 
 func (app *application) listMoviesHandler(writer http.ResponseWriter, req *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Title   string
+		Genres  []string
+		OwnerID int64
 		data.Filters
 	}
 	v := validator.New()
 	qs := req.URL.Query()
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	switch owner := app.readString(qs, "owner", ""); owner {
+	case "":
+	case "me":
+		input.OwnerID = app.contextGetUser(req).ID
+	default:
+		v.AddError("owner", `must be "me"`)
+	}
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Sort = app.readString(qs, "sort", "id")
@@ -187,7 +401,7 @@ func (app *application) listMoviesHandler(writer http.ResponseWriter, req *http.
 		app.failedValidationResponse(writer, req, v.Errors)
 		return
 	}
-	movies, metadata, err := app.models.Movies.List(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.List(app.contextGetMembership(req).OrganizationID, input.Title, input.Genres, input.OwnerID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(writer, req, err)
 		return
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createOrganizationHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	org := &data.Organization{
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+	v := validator.New()
+	if org.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	err = app.models.Organizations.Insert(org, usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "organization.create", data.AuditTargetOrganization, org.ID, org)
+
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listOrganizationsHandler(writer http.ResponseWriter, req *http.Request) {
+	orgs, err := app.models.Organizations.GetAllForUser(app.contextGetUser(req).ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"organizations": orgs}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listMembersHandler(writer http.ResponseWriter, req *http.Request) {
+	members, err := app.models.Memberships.GetAllForOrganization(app.contextGetMembership(req).OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// updateMemberHandler changes the role of a member of the organization. Users join through invitations only.
+func (app *application) updateMemberHandler(writer http.ResponseWriter, req *http.Request) {
+	userID, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	err = app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	orgID := app.contextGetMembership(req).OrganizationID
+	if !app.keepsAnOwner(writer, req, orgID, userID, input.Role) {
+		return
+	}
+	membership := &data.Membership{OrganizationID: orgID, UserID: userID, Role: input.Role}
+	err = app.models.Memberships.UpdateRole(membership)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "organization.member_set", data.AuditTargetOrganization, orgID, map[string]any{"user_id": userID, "role": input.Role})
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"member": membership}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// deleteMemberHandler removes a user from the organization. Owners can remove anybody, other members only
+// themselves.
+func (app *application) deleteMemberHandler(writer http.ResponseWriter, req *http.Request) {
+	userID, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+
+	membership := app.contextGetMembership(req)
+	if membership.Role != data.RoleOwner && membership.UserID != userID {
+		app.notPermittedResponse(writer, req)
+		return
+	}
+	if !app.keepsAnOwner(writer, req, membership.OrganizationID, userID, "") {
+		return
+	}
+
+	err = app.models.Memberships.Delete(membership.OrganizationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "organization.member_remove", data.AuditTargetOrganization, membership.OrganizationID, map[string]any{"user_id": userID})
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// keepsAnOwner refuses to take the owner role away from the last owner of an organization, role is the user's new
+// role or empty when they are being removed.
+func (app *application) keepsAnOwner(writer http.ResponseWriter, req *http.Request, orgID int64, userID int64, role string) bool {
+	if role == data.RoleOwner {
+		return true
+	}
+	current, err := app.models.Memberships.Get(orgID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return true
+		}
+		app.serverErrorResponse(writer, req, err)
+		return false
+	}
+	if current.Role != data.RoleOwner {
+		return true
+	}
+	owners, err := app.models.Memberships.CountOwners(orgID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return false
+	}
+	if owners <= 1 {
+		v := validator.New()
+		v.AddError("role", "an organization must keep at least one owner")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+type testMovie struct {
+	ID      int64  `json:"id"`
+	Title   string `json:"title"`
+	Version int32  `json:"version"`
+}
+
+// newTestOrganization has usr create an organization, making them its owner, and returns its slug.
+func newTestOrganization(t *testing.T, app *application, token string) string {
+	t.Helper()
+	slug := "org-" + randomName(t)
+	rr := request(t, app, http.MethodPost, "/v1/orgs", map[string]string{"name": slug, "slug": slug}, bearer(token)...)
+	checkStatus(t, rr, http.StatusCreated)
+	return slug
+}
+
+func newTestMovie(t *testing.T, app *application, token string, slug string, title string) testMovie {
+	t.Helper()
+	body := map[string]any{"title": title, "year": 2001, "runtime": "120 mins", "genres": []string{"drama"}}
+	rr := request(t, app, http.MethodPost, "/v1/orgs/"+slug+"/movies", body, bearer(token)...)
+	checkStatus(t, rr, http.StatusCreated)
+	var res struct {
+		Movie testMovie `json:"movie"`
+	}
+	decode(t, rr, &res)
+	return res.Movie
+}
+
+func listMovieIDs(t *testing.T, app *application, path string, headers []string) []int64 {
+	t.Helper()
+	rr := request(t, app, http.MethodGet, path, nil, headers...)
+	checkStatus(t, rr, http.StatusOK)
+	var res struct {
+		Movies []testMovie `json:"movies"`
+	}
+	decode(t, rr, &res)
+	var ids []int64
+	for _, movie := range res.Movies {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+// TestOrganizationIsolation checks that nothing in the catalog of one organization can be read or changed from
+// another one, whether the organization is picked by path or by header. Bob holds movies:admin, the permission that
+// lets him touch any movie of his own organization, to show it stops at its boundary.
+func TestOrganizationIsolation(t *testing.T) {
+	app := newTestApplication(t)
+	_, alice := newTestUser(t, app, "movies:read", "movies:write")
+	_, bob := newTestUser(t, app, "movies:read", "movies:write", "movies:admin")
+	orgA := newTestOrganization(t, app, alice)
+	orgB := newTestOrganization(t, app, bob)
+
+	movie := newTestMovie(t, app, alice, orgA, "Alice's movie")
+	rr := request(t, app, http.MethodPatch, fmt.Sprintf("/v1/orgs/%s/movies/%d", orgA, movie.ID), map[string]string{"title": "Alice's movie, revised"}, bearer(alice)...)
+	checkStatus(t, rr, http.StatusOK)
+	trashed := newTestMovie(t, app, alice, orgA, "Alice's trashed movie")
+	rr = request(t, app, http.MethodDelete, fmt.Sprintf("/v1/orgs/%s/movies/%d", orgA, trashed.ID), nil, bearer(alice)...)
+	checkStatus(t, rr, http.StatusOK)
+
+	scopes := []struct {
+		name    string
+		prefix  string
+		headers []string
+	}{
+		{"path", "/v1/orgs/" + orgB, bearer(bob)},
+		{"header", "/v1", append(bearer(bob), "X-Organization", orgB)},
+	}
+	for _, scope := range scopes {
+		t.Run(scope.name, func(t *testing.T) {
+			movieURL := fmt.Sprintf("%s/movies/%d", scope.prefix, movie.ID)
+			notFound := []struct {
+				method string
+				path   string
+				body   any
+			}{
+				{http.MethodGet, movieURL, nil},
+				{http.MethodPatch, movieURL, map[string]string{"title": "Stolen"}},
+				{http.MethodDelete, movieURL, nil},
+				{http.MethodPut, movieURL + "/owner", map[string]int64{"user_id": 1}},
+				{http.MethodGet, movieURL + "/revisions", nil},
+				{http.MethodGet, movieURL + "/revisions/1", nil},
+				{http.MethodPost, movieURL + "/revisions/1/restore", nil},
+				{http.MethodPost, fmt.Sprintf("%s/movies/%d/restore", scope.prefix, trashed.ID), nil},
+				{http.MethodDelete, fmt.Sprintf("%s/movies/trash/%d", scope.prefix, trashed.ID), nil},
+			}
+			for _, tt := range notFound {
+				rr := request(t, app, tt.method, tt.path, tt.body, scope.headers...)
+				if rr.Code != http.StatusNotFound {
+					t.Errorf("%s %s: got status %d, want %d: %s", tt.method, tt.path, rr.Code, http.StatusNotFound, rr.Body.String())
+				}
+			}
+
+			if ids := listMovieIDs(t, app, scope.prefix+"/movies", scope.headers); slices.Contains(ids, movie.ID) {
+				t.Errorf("movie of another organization listed: %v", ids)
+			}
+			if ids := listMovieIDs(t, app, scope.prefix+"/movies/trash", scope.headers); slices.Contains(ids, trashed.ID) {
+				t.Errorf("trash of another organization listed: %v", ids)
+			}
+
+			// NOTE: a batch can't name the same movie twice, so the update and the delete go separately.
+			for _, op := range []map[string]any{
+				{"op": "update", "id": movie.ID, "title": "Stolen"},
+				{"op": "delete", "id": movie.ID},
+			} {
+				body := map[string]any{"operations": []map[string]any{op}}
+				rr := request(t, app, http.MethodPost, scope.prefix+"/movies/batch", body, scope.headers...)
+				checkStatus(t, rr, http.StatusOK)
+				var res struct {
+					Results []batchResult `json:"results"`
+				}
+				decode(t, rr, &res)
+				if len(res.Results) != 1 || res.Results[0].Status != http.StatusNotFound {
+					t.Errorf("batch %s: got %+v, want a single %d result", op["op"], res.Results, http.StatusNotFound)
+				}
+			}
+		})
+	}
+
+	// Naming the other organization doesn't help either, Bob isn't a member.
+	rr = request(t, app, http.MethodGet, fmt.Sprintf("/v1/orgs/%s/movies/%d", orgA, movie.ID), nil, bearer(bob)...)
+	checkStatus(t, rr, http.StatusNotFound)
+	rr = request(t, app, http.MethodGet, fmt.Sprintf("/v1/movies/%d", movie.ID), nil, append(bearer(bob), "X-Organization", orgA)...)
+	checkStatus(t, rr, http.StatusNotFound)
+
+	// And Alice's movie came out of all this untouched.
+	rr = request(t, app, http.MethodGet, fmt.Sprintf("/v1/orgs/%s/movies/%d", orgA, movie.ID), nil, bearer(alice)...)
+	checkStatus(t, rr, http.StatusOK)
+	var res struct {
+		Movie testMovie `json:"movie"`
+	}
+	decode(t, rr, &res)
+	if res.Movie.Title != "Alice's movie, revised" {
+		t.Errorf("got title %q, want %q", res.Movie.Title, "Alice's movie, revised")
+	}
+	if ids := listMovieIDs(t, app, "/v1/orgs/"+orgA+"/movies/trash", bearer(alice)); !slices.Contains(ids, trashed.ID) {
+		t.Errorf("trashed movie is gone from the trash: %v", ids)
+	}
+}
+
+func TestUpdateMemberOnlyChangesExistingMembers(t *testing.T) {
+	app := newTestApplication(t)
+	_, alice := newTestUser(t, app)
+	bob, bobToken := newTestUser(t, app)
+	org := newTestOrganization(t, app, alice)
+
+	rr := request(t, app, http.MethodPut, fmt.Sprintf("/v1/orgs/%s/members/%d", org, bob.ID), map[string]string{"role": "editor"}, bearer(alice)...)
+	checkStatus(t, rr, http.StatusNotFound)
+
+	// Bob still belongs to the default organization only, so requests without X-Organization keep working for him.
+	rr = request(t, app, http.MethodGet, "/v1/orgs", nil, bearer(bobToken)...)
+	checkStatus(t, rr, http.StatusOK)
+	var res struct {
+		Organizations []struct {
+			Slug string `json:"slug"`
+		} `json:"organizations"`
+	}
+	decode(t, rr, &res)
+	if len(res.Organizations) != 1 {
+		t.Errorf("got %d organizations, want 1", len(res.Organizations))
+	}
+}
@@ -1,16 +1,74 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+)
 
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /v1/healthcheck", app.healthcheckHandler)
-	mux.HandleFunc("GET /v1/movies", app.listMoviesHandler)
-	mux.HandleFunc("POST /v1/movies", app.createMovieHandler)
-	mux.HandleFunc("GET /v1/movies/{id}", app.showMovieHandler)
-	mux.HandleFunc("PATCH /v1/movies/{id}", app.updateMovieHandler)
-	mux.HandleFunc("DELETE /v1/movies/{id}", app.deleteMovieHandler)
+	mux.HandleFunc("GET /.well-known/jwks.json", app.jwksHandler)
+	// NOTE: the catalog of an organization is served both under its own prefix and under /v1 with the organization
+	// picked by the X-Organization header, see requireOrganization.
+	for _, prefix := range []string{"/v1", "/v1/orgs/{org}"} {
+		mux.HandleFunc("GET "+prefix+"/movies", app.requirePermission("movies:read", app.requireOrganization(app.listMoviesHandler)))
+		mux.HandleFunc("POST "+prefix+"/movies", app.requirePermission("movies:write", app.requireOrganizationRole(app.createMovieHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("GET "+prefix+"/movies/{id}", app.requirePermission("movies:read", app.requireOrganization(app.showMovieHandler)))
+		mux.HandleFunc("PATCH "+prefix+"/movies/{id}", app.requirePermission("movies:write", app.requireOrganizationRole(app.updateMovieHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("DELETE "+prefix+"/movies/{id}", app.requirePermission("movies:write", app.requireOrganizationRole(app.deleteMovieHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("PUT "+prefix+"/movies/{id}/owner", app.requirePermission("movies:admin", app.requireOrganization(app.transferMovieHandler)))
+		mux.HandleFunc("POST "+prefix+"/movies/batch", app.requirePermission("movies:write", app.requireOrganizationRole(app.batchMoviesHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("GET "+prefix+"/movies/trash", app.requirePermission("movies:write", app.requireOrganizationRole(app.listTrashHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("POST "+prefix+"/movies/{id}/restore", app.requirePermission("movies:write", app.requireOrganizationRole(app.restoreMovieHandler, data.RoleOwner, data.RoleEditor)))
+		mux.HandleFunc("DELETE "+prefix+"/movies/trash/{id}", app.requirePermission("movies:admin", app.requireOrganization(app.purgeMovieHandler)))
+		mux.HandleFunc("GET "+prefix+"/movies/{id}/revisions", app.requirePermission("movies:read", app.requireOrganization(app.listMovieRevisionsHandler)))
+		mux.HandleFunc("GET "+prefix+"/movies/{id}/revisions/{version}", app.requirePermission("movies:read", app.requireOrganization(app.showMovieRevisionHandler)))
+		mux.HandleFunc("POST "+prefix+"/movies/{id}/revisions/{version}/restore", app.requirePermission("movies:write", app.requireOrganizationRole(app.restoreMovieRevisionHandler, data.RoleOwner, data.RoleEditor)))
+	}
+	mux.HandleFunc("POST /v1/orgs", app.requireSessionUser(app.requireActivatedUser(app.createOrganizationHandler)))
+	mux.HandleFunc("GET /v1/orgs", app.requireSessionUser(app.requireActivatedUser(app.listOrganizationsHandler)))
+	mux.HandleFunc("GET /v1/orgs/{org}/members", app.requireSessionUser(app.requireActivatedUser(app.requireOrganization(app.listMembersHandler))))
+	mux.HandleFunc("PUT /v1/orgs/{org}/members/{id}", app.requireSessionUser(app.requireActivatedUser(app.requireOrganizationRole(app.updateMemberHandler, data.RoleOwner))))
+	mux.HandleFunc("DELETE /v1/orgs/{org}/members/{id}", app.requireSessionUser(app.requireActivatedUser(app.requireOrganization(app.deleteMemberHandler))))
+	mux.HandleFunc("POST /v1/orgs/{org}/invitations", app.requireSessionUser(app.requireActivatedUser(app.requireOrganizationRole(app.createInvitationHandler, data.RoleOwner))))
+	mux.HandleFunc("GET /v1/orgs/{org}/invitations", app.requireSessionUser(app.requireActivatedUser(app.requireOrganizationRole(app.listInvitationsHandler, data.RoleOwner))))
+	mux.HandleFunc("DELETE /v1/orgs/{org}/invitations/{id}", app.requireSessionUser(app.requireActivatedUser(app.requireOrganizationRole(app.deleteInvitationHandler, data.RoleOwner))))
+	mux.HandleFunc("PUT /v1/invitations/accepted", app.acceptInvitationHandler)
 	mux.HandleFunc("POST /v1/users", app.createUserHandler)
 	mux.HandleFunc("PUT /v1/users/activated", app.activateUserHandler)
-	return app.recoverPanic(app.rateLimit(mux))
+	mux.HandleFunc("PUT /v1/users/password", app.updateUserPasswordHandler)
+	mux.HandleFunc("PUT /v1/users/email", app.confirmUserEmailHandler)
+	mux.HandleFunc("PUT /v1/users/email/cancel", app.cancelUserEmailHandler)
+	mux.HandleFunc("PATCH /v1/users/me/email", app.requireSessionUser(app.requireActivatedUser(app.updateUserEmailHandler)))
+	mux.HandleFunc("POST /v1/users/me/totp", app.requireSessionUser(app.requireActivatedUser(app.createTOTPHandler)))
+	mux.HandleFunc("PUT /v1/users/me/totp", app.requireSessionUser(app.requireActivatedUser(app.confirmTOTPHandler)))
+	mux.HandleFunc("DELETE /v1/users/me/totp", app.requireSessionUser(app.requireActivatedUser(app.deleteTOTPHandler)))
+	mux.HandleFunc("GET /v1/users/me", app.requireSessionUser(app.requireAuthenticatedUser(app.showCurrentUserHandler)))
+	mux.HandleFunc("PATCH /v1/users/me", app.requireSessionUser(app.requireActivatedUser(app.updateCurrentUserHandler)))
+	mux.HandleFunc("DELETE /v1/users/me", app.requireSessionUser(app.requireAuthenticatedUser(app.deleteCurrentUserHandler)))
+	mux.HandleFunc("GET /v1/users/me/export", app.requireSessionUser(app.requireAuthenticatedUser(app.exportCurrentUserHandler)))
+	mux.HandleFunc("PUT /v1/users/deletion/cancel", app.cancelUserDeletionHandler)
+	mux.HandleFunc("GET /v1/users/me/tokens", app.requireSessionUser(app.requireAuthenticatedUser(app.listUserTokensHandler)))
+	mux.HandleFunc("DELETE /v1/users/me/tokens", app.requireSessionUser(app.requireAuthenticatedUser(app.deleteAllUserTokensHandler)))
+	mux.HandleFunc("DELETE /v1/users/me/tokens/{id}", app.requireSessionUser(app.requireAuthenticatedUser(app.deleteUserTokenHandler)))
+	mux.HandleFunc("GET /v1/api-keys", app.requireSessionUser(app.requirePermission("apikeys:write", app.listAPIKeysHandler)))
+	mux.HandleFunc("POST /v1/api-keys", app.requireSessionUser(app.requirePermission("apikeys:write", app.createAPIKeyHandler)))
+	mux.HandleFunc("DELETE /v1/api-keys/{id}", app.requireSessionUser(app.requirePermission("apikeys:write", app.deleteAPIKeyHandler)))
+	mux.HandleFunc("GET /v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	mux.HandleFunc("GET /v1/admin/users/{id}", app.requirePermission("users:admin", app.showUserHandler))
+	mux.HandleFunc("POST /v1/admin/users/{id}/deactivate", app.requirePermission("users:admin", app.deactivateUserHandler))
+	mux.HandleFunc("POST /v1/admin/users/{id}/reactivate", app.requirePermission("users:admin", app.reactivateUserHandler))
+	mux.HandleFunc("POST /v1/admin/users/{id}/password-reset", app.requirePermission("users:admin", app.forcePasswordResetHandler))
+	mux.HandleFunc("PATCH /v1/admin/users/{id}/permissions", app.requirePermission("users:admin", app.updateUserPermissionsHandler))
+	mux.HandleFunc("DELETE /v1/admin/users/{id}/lockout", app.requirePermission("users:admin", app.unlockUserHandler))
+	mux.HandleFunc("GET /v1/admin/audit", app.requirePermission("users:admin", app.listAuditEventsHandler))
+	mux.HandleFunc("POST /v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/activation", app.createActivationTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	mux.HandleFunc("POST /v1/tokens/magic-link/authentication", app.exchangeMagicLinkTokenHandler)
+	return app.requestID(app.recoverPanic(app.rateLimit(app.authenticate(mux))))
 }
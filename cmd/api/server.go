@@ -22,6 +22,7 @@ func (app *application) serve() error {
 		ErrorLog:     log.New(app.logger, "", 0),
 	}
 	shutdownError := make(chan error)
+	stopMaintenance := make(chan struct{})
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -40,9 +41,11 @@ func (app *application) serve() error {
 		app.logger.Info("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
+		close(stopMaintenance)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
+	app.startMaintenance(stopMaintenance)
 	app.logger.Info("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/jwt"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createAuthenticationTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if usr == nil {
+		usr = data.AnonymousUser
+	}
+
+	// NOTE: lockouts are checked before the password so a locked account doesn't cost us a bcrypt comparison.
+	keys := []string{data.IPLoginKey(app.clientIP(req))}
+	if !usr.IsAnonymous() {
+		keys = append(keys, data.UserLoginKey(usr.ID))
+	}
+	lockedUntil, err := app.models.LoginFailures.LockedUntil(keys...)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.loginLockedResponse(writer, req, lockedUntil)
+		return
+	}
+
+	if usr.IsAnonymous() {
+		app.failedLoginResponse(writer, req, usr)
+		return
+	}
+	match, err := usr.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if !match {
+		app.failedLoginResponse(writer, req, usr)
+		return
+	}
+	if usr.DeactivatedAt != nil {
+		app.deactivatedAccountResponse(writer, req)
+		return
+	}
+	if usr.DeletionScheduledAt != nil {
+		app.deletionScheduledResponse(writer, req)
+		return
+	}
+
+	if !app.verifySecondFactor(writer, req, usr, input.TOTPCode) {
+		return
+	}
+	if usr.Password.NeedsRehash() {
+		app.rehashPassword(usr, input.Password)
+	}
+	app.startSession(writer, req, usr, "password")
+}
+
+// verifySecondFactor checks code when usr has two-factor authentication enabled. It answers the request itself and
+// returns false when the login must not go ahead.
+func (app *application) verifySecondFactor(writer http.ResponseWriter, req *http.Request, usr *data.User, code string) bool {
+	otp, err := app.models.TOTP.Get(usr.ID)
+	switch {
+	case err == nil && otp.Confirmed:
+		if code == "" {
+			app.twoFactorRequiredResponse(writer, req)
+			return false
+		}
+		ok, err := app.checkSecondFactor(otp, code)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return false
+		}
+		if !ok {
+			app.failedLoginResponse(writer, req, usr)
+			return false
+		}
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(writer, req, err)
+		return false
+	}
+	return true
+}
+
+// startSession issues an access and a refresh token to a user who just proved who they are, method says how.
+func (app *application) startSession(writer http.ResponseWriter, req *http.Request, usr *data.User, method string) {
+	err := app.models.LoginFailures.Reset(data.UserLoginKey(usr.ID))
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	token, err := app.newAccessToken(req, usr, family)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	refreshToken, err := app.models.Tokens.NewSession(usr.ID, app.config.auth.refreshTTL, data.ScopeRefresh, family, req.UserAgent(), app.clientIP(req))
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	app.recordAudit(req, &data.AuditEvent{
+		ActorID:    &usr.ID,
+		Action:     "user.login",
+		TargetType: data.AuditTargetUser,
+		TargetID:   &usr.ID,
+		Details:    map[string]any{"method": method},
+	})
+
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// rehashPassword upgrades a stored hash to the current algorithm and parameters. The login has already succeeded, so
+// failures are only logged and the old hash keeps working.
+func (app *application) rehashPassword(usr *data.User, plaintext string) {
+	err := usr.Password.Set(plaintext)
+	if err == nil {
+		err = app.models.Users.Update(usr)
+	}
+	if err != nil {
+		app.logger.Error(err, map[string]string{
+			"user_id": strconv.FormatInt(usr.ID, 10),
+			"action":  "password rehash",
+		})
+	}
+}
+
+// failedLoginResponse records a failed login and answers with the same invalid credentials error whatever went wrong,
+// so callers can't tell an unknown email from a wrong password.
+func (app *application) failedLoginResponse(writer http.ResponseWriter, req *http.Request, usr *data.User) {
+	err := app.recordFailedLogin(req, usr)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	app.invalidCredentialsResponse(writer, req)
+}
+
+// recordFailedLogin counts a failed login against the client IP and, when usr is known, the account. Locking an
+// account is logged and the owner is told by email.
+func (app *application) recordFailedLogin(req *http.Request, usr *data.User) error {
+	ip := app.clientIP(req)
+	ipPolicy := app.loginPolicy(app.config.login.maxAttemptsIP)
+	lockedUntil, err := app.models.LoginFailures.RecordFailure(data.IPLoginKey(ip), ipPolicy)
+	if err != nil {
+		return err
+	}
+	if !lockedUntil.IsZero() {
+		app.logger.Warn("ip locked after repeated failed logins", map[string]string{
+			"ip":           ip,
+			"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+		})
+	}
+
+	// NOTE: AnonymousUser has ID 0, so unknown emails are logged without a target.
+	app.audit(req, "user.login_failed", data.AuditTargetUser, usr.ID, nil)
+
+	if usr.IsAnonymous() {
+		return nil
+	}
+	lockedUntil, err = app.models.LoginFailures.RecordFailure(data.UserLoginKey(usr.ID), app.loginPolicy(app.config.login.maxAttempts))
+	if err != nil {
+		return err
+	}
+	if !lockedUntil.IsZero() {
+		app.logger.Warn("account locked after repeated failed logins", map[string]string{
+			"user_id":      strconv.FormatInt(usr.ID, 10),
+			"ip":           ip,
+			"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+		})
+		app.background(func() {
+			data := map[string]any{
+				"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+				"ip":          ip,
+			}
+			err := app.mailer.Send(usr.Email, "account_locked.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err, nil)
+			}
+		})
+	}
+	return nil
+}
+
+// verifyPassword makes a signed in user prove again who they are before a sensitive change. It goes through the same
+// lockout as a login and counts failures the same way; on failure it answers the request itself, reporting a wrong
+// password against field.
+func (app *application) verifyPassword(writer http.ResponseWriter, req *http.Request, usr *data.User, field string, plaintext string) bool {
+	if app.loginLocked(writer, req, usr) {
+		return false
+	}
+
+	match, err := usr.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return false
+	}
+	if !match {
+		err := app.recordFailedLogin(req, usr)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return false
+		}
+		v := validator.New()
+		v.AddError(field, "is incorrect")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return false
+	}
+	return true
+}
+
+// loginLocked reports whether the client IP or usr is locked out after repeated failed logins, answering the request
+// itself when it is.
+func (app *application) loginLocked(writer http.ResponseWriter, req *http.Request, usr *data.User) bool {
+	lockedUntil, err := app.models.LoginFailures.LockedUntil(data.IPLoginKey(app.clientIP(req)), data.UserLoginKey(usr.ID))
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return true
+	}
+	if !lockedUntil.IsZero() {
+		app.loginLockedResponse(writer, req, lockedUntil)
+		return true
+	}
+	return false
+}
+
+func (app *application) loginPolicy(maxAttempts int) data.LoginPolicy {
+	return data.LoginPolicy{
+		MaxAttempts: maxAttempts,
+		Lockout:     app.config.login.lockout,
+		MaxLockout:  app.config.login.maxLockout,
+		Window:      app.config.login.window,
+	}
+}
+
+func (app *application) refreshAuthenticationTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"refresh_token"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.TokenPlainText != "", "refresh_token", "must be provided")
+	v.Check(len(input.TokenPlainText) == 26, "refresh_token", "must be 26 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.Rotate(input.TokenPlainText, app.config.auth.refreshTTL, req.UserAgent(), app.clientIP(req))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.Warn("refresh token reuse detected, token family revoked", map[string]string{
+				"user_id":     strconv.FormatInt(refreshToken.UserID, 10),
+				"family":      hex.EncodeToString(refreshToken.Family),
+				"remote_addr": req.RemoteAddr,
+				"user_agent":  req.UserAgent(),
+			})
+			app.invalidAuthenticationTokenResponse(writer, req)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	usr, err := app.models.Users.Get(refreshToken.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	token, err := app.newAccessToken(req, usr, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// newAccessToken issues the short-lived token clients send as a bearer token, in whichever format -auth-mode selects.
+func (app *application) newAccessToken(req *http.Request, usr *data.User, family []byte) (*data.Token, error) {
+	switch app.config.auth.mode {
+	case "jwt":
+		return app.newJWT(usr)
+	default:
+		return app.models.Tokens.NewSession(usr.ID, app.config.auth.accessTTL, data.ScopeAuthentication, family, req.UserAgent(), app.clientIP(req))
+	}
+}
+
+// newJWT signs a stateless access token for usr. It is never stored, so it only carries the hashed-token fields that
+// make sense to a client: the token itself and its expiry.
+func (app *application) newJWT(usr *data.User) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTTL)
+	signed, err := app.jwtKeys.Sign(jwt.Claims{
+		Subject:     strconv.FormatInt(usr.ID, 10),
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		Expiry:      expiry.Unix(),
+		Activated:   usr.Activated,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data.Token{PlainText: signed, UserID: usr.ID, Expiry: expiry, Scope: data.ScopeAuthentication, CreatedAt: now}, nil
+}
+
+func (app *application) jwksHandler(writer http.ResponseWriter, req *http.Request) {
+	if app.jwtKeys == nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+	err := app.writeJSON(writer, http.StatusOK, envelope{"keys": app.jwtKeys.JWKS()}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	// NOTE: the response is the same whether or not the account exists, so this endpoint can't be used to enumerate users.
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it containing password reset instructions"}
+
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(writer, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(writer, req, err)
+			}
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	if usr.Activated {
+		token, err := app.models.Tokens.New(usr.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.PlainText,
+			}
+			err := app.mailer.Send(usr.Email, "token_password_reset.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(writer, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	if app.config.limiter.enabled && !app.activationLimiter.allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(writer, req)
+		return
+	}
+
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if usr.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	if usr.DeactivatedAt != nil {
+		app.deactivatedAccountResponse(writer, req)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"activationToken": token.PlainText,
+			"userID":          usr.ID,
+		}
+		err := app.mailer.Send(usr.Email, "user_welcome.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	err = app.writeJSON(writer, http.StatusAccepted, envelope{"message": "an email will be sent to you containing activation instructions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) listUserTokensHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	tokens, err := app.models.Tokens.GetAllForUser(usr)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteUserTokenHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := app.getIdParam(req)
+	if err != nil {
+		app.notFoundResponse(writer, req)
+		return
+	}
+	usr := app.contextGetUser(req)
+	err = app.models.Tokens.DeleteForUser(id, usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteAllUserTokensHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+	err := app.writeJSON(writer, http.StatusOK, envelope{"message": "logged out of every session"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/data"
+	"github.com/PedroDrago/greenlight/internal/totp"
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func (app *application) createTOTPHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.models.TOTP.SetPending(usr.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(writer, req, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret": secret,
+		"uri":    totp.URI("Greenlight", usr.Email, secret),
+	}}
+	err = app.writeJSON(writer, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	otp, err := app.models.TOTP.Get(usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor enrolment has not been started")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if otp.Confirmed {
+		app.errorResponse(writer, req, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := totp.Validate(otp.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	recoveryCodes, hashes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	err = app.models.TOTP.Confirm(usr.ID, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// deleteTOTPHandler turns two-factor authentication off. A stolen session alone must not be enough, so the user has
+// to present either their current password or a code from their authenticator.
+func (app *application) deleteTOTPHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "" || input.Code != "", "password", "either the password or a code must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	otp, err := app.models.TOTP.Get(usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	if input.Password != "" {
+		if !app.verifyPassword(writer, req, usr, "password", input.Password) {
+			return
+		}
+	} else {
+		// NOTE: codes are only six digits, so they go through the same lockout as a login.
+		if app.loginLocked(writer, req, usr) {
+			return
+		}
+		ok, err := app.checkSecondFactor(otp, input.Code)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+		if !ok {
+			err := app.recordFailedLogin(req, usr)
+			if err != nil {
+				app.serverErrorResponse(writer, req, err)
+				return
+			}
+			v.AddError("code", "invalid code")
+			app.failedValidationResponse(writer, req, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.TOTP.Delete(usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	app.audit(req, "user.totp_disable", data.AuditTargetUser, usr.ID, nil)
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// checkSecondFactor accepts either a current TOTP code or one of the user's unused recovery codes.
+func (app *application) checkSecondFactor(otp *data.TOTP, code string) (bool, error) {
+	if step, ok := totp.Validate(otp.Secret, code, time.Now()); ok {
+		return app.models.TOTP.UseStep(otp.UserID, step)
+	}
+	return app.models.TOTP.UseRecoveryCode(otp.UserID, code)
+}
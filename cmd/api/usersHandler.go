@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/PedroDrago/greenlight/internal/data"
@@ -36,7 +39,7 @@ func (app *application) createUserHandler(writer http.ResponseWriter, req *http.
 		app.failedValidationResponse(writer, req, v.Errors)
 		return
 	}
-	err = app.models.Users.Insert(usr)
+	token, err := app.models.Users.Register(usr, 3*24*time.Hour)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -47,11 +50,7 @@ func (app *application) createUserHandler(writer http.ResponseWriter, req *http.
 		}
 		return
 	}
-	token, err := app.models.Tokens.New(usr.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(writer, req, err)
-		return
-	}
+	app.recordAudit(req, &data.AuditEvent{ActorID: &usr.ID, Action: "user.register", TargetType: data.AuditTargetUser, TargetID: &usr.ID})
 
 	app.background(func() {
 		data := map[string]any{
@@ -112,9 +111,558 @@ func (app *application) activateUserHandler(writer http.ResponseWriter, req *htt
 		app.serverErrorResponse(writer, req, err)
 		return
 	}
+	app.recordAudit(req, &data.AuditEvent{ActorID: &usr.ID, Action: "user.activate", TargetType: data.AuditTargetUser, TargetID: &usr.ID})
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) updateUserPasswordHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlainText)
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	err = usr.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	if usr.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopePasswordReset, data.ScopeRefresh, data.ScopeMagicLink} {
+		err = app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) updateUserEmailHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	if strings.EqualFold(usr.Email, input.Email) {
+		v.AddError("email", "must be different from the current email address")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+	if !app.verifyPassword(writer, req, usr, "current_password", input.CurrentPassword) {
+		return
+	}
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	usr.PendingEmail = &input.Email
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailChangeCancel} {
+		err = app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+	token, err := app.models.Tokens.New(usr.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	cancelToken, err := app.models.Tokens.New(usr.ID, 24*time.Hour, data.ScopeEmailChangeCancel)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"emailChangeToken": token.PlainText,
+		}
+		err := app.mailer.Send(input.Email, "email_change_confirm.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+	app.background(func() {
+		data := map[string]any{
+			"newEmail":    input.Email,
+			"cancelToken": cancelToken.PlainText,
+		}
+		err := app.mailer.Send(usr.Email, "email_change_notice.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	err = app.writeJSON(writer, http.StatusAccepted, envelope{"message": "an email will be sent to the new address containing confirmation instructions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) confirmUserEmailHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	if usr.PendingEmail == nil {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr.Email = *usr.PendingEmail
+	usr.PendingEmail = nil
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(writer, req, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	// NOTE: sign-in and reset links mailed to the old address must stop working along with it, and so must every
+	// session, in case the change was made from a stolen one.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailChangeCancel, data.ScopeMagicLink, data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
 
 	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, req, err)
 	}
 }
+
+// cancelUserEmailHandler drops a pending email change using the token mailed to the current address, and signs the
+// user out everywhere since the change may have been asked for from a stolen session.
+func (app *application) cancelUserEmailHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetForToken(data.ScopeEmailChangeCancel, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired cancellation token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	usr.PendingEmail = nil
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailChangeCancel, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "the email address change was cancelled and every session signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	headers := make(http.Header)
+	headers.Set("X-Version", strconv.Itoa(int(usr.Version)))
+	err := app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+
+	// NOTE: clients can send back the X-Version they read to make sure they are not overwriting a newer edit.
+	if expected := req.Header.Get("X-Expected-Version"); expected != "" {
+		if strconv.Itoa(int(usr.Version)) != expected {
+			app.editConflictResponse(writer, req)
+			return
+		}
+	}
+
+	var input struct {
+		Name            *string          `json:"name"`
+		Preferences     data.Preferences `json:"preferences"`
+		Password        *string          `json:"password"`
+		CurrentPassword *string          `json:"current_password"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Name != nil {
+		usr.Name = *input.Name
+	}
+	if input.Preferences != nil {
+		usr.Preferences = input.Preferences
+	}
+	if input.Password != nil {
+		if input.CurrentPassword == nil {
+			v.AddError("current_password", "must be provided to change the password")
+			app.failedValidationResponse(writer, req, v.Errors)
+			return
+		}
+		if !app.verifyPassword(writer, req, usr, "current_password", *input.CurrentPassword) {
+			return
+		}
+		err = usr.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(writer, req, err)
+			return
+		}
+	}
+
+	if usr.Validate(v); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	// NOTE: a new password signs every session out, including this one, so whoever may have had the old one is locked
+	// out too.
+	if input.Password != nil {
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			err = app.models.Tokens.DeleteAllForUser(scope, usr.ID)
+			if err != nil {
+				app.serverErrorResponse(writer, req, err)
+				return
+			}
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("X-Version", strconv.Itoa(int(usr.Version)))
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "", "password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr := app.contextGetUser(req)
+	if !app.verifyPassword(writer, req, usr, "password", input.Password) {
+		return
+	}
+
+	deletionAt := time.Now().Add(app.config.deletionGracePeriod)
+	usr.DeletionScheduledAt = &deletionAt
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.RevokeAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, app.config.deletionGracePeriod, data.ScopeDeletionCancel)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"cancelToken": token.PlainText,
+			"deletionAt":  deletionAt.UTC().Format(time.RFC1123),
+		}
+		err := app.mailer.Send(usr.Email, "account_deletion.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	err = app.writeJSON(writer, http.StatusAccepted, envelope{"message": "your account is scheduled for deletion, an email will be sent to you with instructions to cancel it", "deletion_scheduled_at": deletionAt}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+func (app *application) cancelUserDeletionHandler(writer http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+	err := app.readJSON(writer, req, &input)
+	if err != nil {
+		app.badRequestResponse(writer, req, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(writer, req, v.Errors)
+		return
+	}
+
+	usr, err := app.models.Users.GetForToken(data.ScopeDeletionCancel, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired cancellation token")
+			app.failedValidationResponse(writer, req, v.Errors)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+
+	usr.DeletionScheduledAt = nil
+	err = app.models.Users.Update(usr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, req)
+		default:
+			app.serverErrorResponse(writer, req, err)
+		}
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeDeletionCancel, usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	err = app.writeJSON(writer, http.StatusOK, envelope{"user": usr}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
+
+// exportCurrentUserHandler returns everything we hold about the user as a single downloadable JSON document.
+func (app *application) exportCurrentUserHandler(writer http.ResponseWriter, req *http.Request) {
+	usr := app.contextGetUser(req)
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	tokens, err := app.models.Tokens.GetAllForUser(usr)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	apiKeys, err := app.models.APIKeys.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	memberships, err := app.models.Organizations.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	movies, err := app.models.Movies.GetAllCreatedBy(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	auditEvents, err := app.models.Audit.GetAllForActor(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+	twoFactor := false
+	otp, err := app.models.TOTP.Get(usr.ID)
+	switch {
+	case err == nil:
+		twoFactor = otp.Confirmed
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(writer, req, err)
+		return
+	}
+
+	env := envelope{
+		"exported_at":        time.Now(),
+		"user":               usr,
+		"permissions":        permissions,
+		"tokens":             tokens,
+		"api_keys":           apiKeys,
+		"two_factor_enabled": twoFactor,
+		"organizations":      memberships,
+		"movies":             movies,
+		"audit_events":       auditEvents,
+	}
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-user-%d.json"`, usr.ID))
+	err = app.writeJSON(writer, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, req, err)
+	}
+}
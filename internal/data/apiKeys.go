@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+const apiKeyPrefix = "gl_"
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// APIKey lets a machine client act on behalf of a user, restricted to a subset of that user's permissions. Like
+// tokens, only the SHA-256 hash of the key is stored; Prefix is kept so users can tell their keys apart.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	PlainText   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+}
+
+func GenerateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := apiKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+	return &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      plaintext[:len(apiKeyPrefix)+6],
+		PlainText:   plaintext,
+		Hash:        hash[:],
+		Permissions: permissions,
+		Expiry:      expiry,
+	}, nil
+}
+
+func (key *APIKey) Validate(v *validator.Validator, granted Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(granted.Include(code), "permissions", "must only contain permissions you have been granted")
+	}
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+    INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Permissions), key.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+    SELECT id, user_id, name, prefix, permissions, expiry, created_at, last_used_at
+    FROM api_keys
+    WHERE hash = $1
+    AND (expiry IS NULL OR expiry > NOW())`
+
+	var key APIKey
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := []any{
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Permissions),
+		&key.Expiry,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	}
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(args...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+    SELECT id, user_id, name, prefix, permissions, expiry, created_at, last_used_at
+    FROM api_keys
+    WHERE user_id = $1
+    ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		args := []any{
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.Expiry,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		}
+		err := rows.Scan(args...)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Touch records that a key was just used, at most once a minute.
+func (m APIKeyModel) Touch(id int64) error {
+	query := `
+    UPDATE api_keys
+    SET last_used_at = NOW()
+    WHERE id = $1
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func (m APIKeyModel) DeleteForUser(id int64, userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser revokes every API key the user created.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
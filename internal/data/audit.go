@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	AuditTargetUser         = "user"
+	AuditTargetMovie        = "movie"
+	AuditTargetOrganization = "organization"
+)
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// AuditEvent records who did what to which record. Rows are only ever inserted, the table refuses updates and
+// deletes.
+//
+// That includes the personal data in them: the IP address and user agent of every request, and the actor's user ID.
+// Events outlive the account they refer to, when a user is deleted their events stay behind with an actor ID that no
+// longer resolves to anyone, as the security record of what was done. Users can get a copy of the events they are the
+// actor of through the account export, see AuditModel.GetAllForActor.
+type AuditEvent struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ActorID    *int64    `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   *int64    `json:"target_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	RequestID  string    `json:"request_id"`
+	Details    any       `json:"details"`
+	Diff       AuditDiff `json:"diff,omitempty"`
+}
+
+// AuditChange is the value of a single field before and after a change.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// AuditDiff maps the JSON name of every changed field to its change.
+type AuditDiff map[string]AuditChange
+
+// Diff compares the JSON representations of before and after, so fields hidden from the API (like password hashes)
+// never end up in the audit log.
+func Diff(before, after any) (AuditDiff, error) {
+	from, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := AuditDiff{}
+	for key, value := range to {
+		if !reflect.DeepEqual(from[key], value) {
+			diff[key] = AuditChange{From: from[key], To: value}
+		}
+	}
+	for key, value := range from {
+		if _, ok := to[key]; !ok {
+			diff[key] = AuditChange{From: value, To: nil}
+		}
+	}
+	return diff, nil
+}
+
+func jsonFields(v any) (map[string]any, error) {
+	if v == nil {
+		return map[string]any{}, nil
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("cannot diff %T: %w", v, err)
+	}
+	return fields, nil
+}
+
+func (m AuditModel) Insert(event *AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+	var diff []byte
+	if event.Diff != nil {
+		diff, err = json.Marshal(event.Diff)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+    INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, user_agent, request_id, details, diff)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    RETURNING id, created_at`
+
+	args := []any{event.ActorID, event.Action, event.TargetType, event.TargetID, event.IP, event.UserAgent, event.RequestID, details, diff}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// AuditFilter narrows List down, zero values match everything.
+type AuditFilter struct {
+	ActorID int64
+	Action  string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (m AuditModel) List(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, created_at, actor_id, action, target_type, target_id, ip, user_agent, request_id, details, diff
+    FROM audit_events
+    WHERE ($1 = 0 OR actor_id = $1)
+    AND ($2 = '' OR action = $2)
+    AND ($3::timestamptz IS NULL OR created_at >= $3)
+    AND ($4::timestamptz IS NULL OR created_at < $4)
+    ORDER BY %s %s, id ASC
+    LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{filter.ActorID, filter.Action, nullTime(filter.Since), nullTime(filter.Until), filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var details, diff []byte
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&details,
+			&diff,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		err = event.unmarshal(details, diff)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
+
+// GetAllForActor returns every event actorID is the actor of, oldest first.
+func (m AuditModel) GetAllForActor(actorID int64) ([]*AuditEvent, error) {
+	query := `
+    SELECT id, created_at, actor_id, action, target_type, target_id, ip, user_agent, request_id, details, diff
+    FROM audit_events
+    WHERE actor_id = $1
+    ORDER BY created_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var details, diff []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&details,
+			&diff,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = event.unmarshal(details, diff)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (event *AuditEvent) unmarshal(details []byte, diff []byte) error {
+	err := json.Unmarshal(details, &event.Details)
+	if err != nil {
+		return err
+	}
+	if diff != nil {
+		return json.Unmarshal(diff, &event.Diff)
+	}
+	return nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Invitation lets whoever controls Email join an organization with Role. Like tokens, only a hash of the plaintext
+// is stored.
+type Invitation struct {
+	ID               int64     `json:"id"`
+	Hash             []byte    `json:"-"`
+	PlainText        string    `json:"-"`
+	OrganizationID   int64     `json:"organization_id"`
+	OrganizationName string    `json:"-"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	InvitedBy        *int64    `json:"invited_by"`
+	Expiry           time.Time `json:"expiry"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// New invites email to orgID, replacing any invitation still pending for that address.
+func (m InvitationModel) New(orgID int64, email string, role string, invitedBy int64, ttl time.Duration) (*Invitation, error) {
+	token, err := GenerateToken(0, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+	invitation := &Invitation{
+		Hash:           token.Hash,
+		PlainText:      token.PlainText,
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      &invitedBy,
+		Expiry:         token.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+    DELETE FROM invitations
+    WHERE organization_id = $1 AND email = $2`
+	_, err = tx.ExecContext(ctx, query, orgID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+    INSERT INTO invitations (hash, organization_id, email, role, invited_by, expiry)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, created_at`
+	args := []any{invitation.Hash, orgID, email, role, invitedBy, invitation.Expiry}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, tx.Commit()
+}
+
+// GetAllForOrganization returns the invitations of orgID that can still be accepted.
+func (m InvitationModel) GetAllForOrganization(orgID int64) ([]*Invitation, error) {
+	query := `
+    SELECT id, organization_id, email, role, invited_by, expiry, created_at
+    FROM invitations
+    WHERE organization_id = $1 AND expiry > $2
+    ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.OrganizationID,
+			&invitation.Email,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.Expiry,
+			&invitation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (m InvitationModel) GetForToken(tokenPlainText string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	query := `
+    SELECT invitations.id, invitations.organization_id, organizations.name, invitations.email, invitations.role,
+        invitations.invited_by, invitations.expiry, invitations.created_at
+    FROM invitations
+    INNER JOIN organizations ON organizations.id = invitations.organization_id
+    WHERE invitations.hash = $1 AND invitations.expiry > $2`
+
+	var invitation Invitation
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.OrganizationID,
+		&invitation.OrganizationName,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.Expiry,
+		&invitation.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &invitation, nil
+}
+
+// Delete revokes an invitation, returning ErrRecordNotFound when it is already gone.
+func (m InvitationModel) Delete(orgID int64, id int64) error {
+	query := `
+    DELETE FROM invitations
+    WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Accept uses up invitation and makes usr a member of its organization in a single transaction. A usr without an ID
+// is registered first, as an activated user with the permissions and default organization every new user gets. Users
+// who already are members keep their role. It returns ErrRecordNotFound when the invitation was already used, and
+// ErrDuplicateEmail when usr registered in the meantime.
+func (m InvitationModel) Accept(invitation *Invitation, usr *User) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+    DELETE FROM invitations
+    WHERE id = $1 AND organization_id = $2`
+	res, err := tx.ExecContext(ctx, query, invitation.ID, invitation.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	if usr.ID == 0 {
+		usr.Activated = true
+		err = registerUser(ctx, tx, usr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	membership := &Membership{OrganizationID: invitation.OrganizationID, UserID: usr.ID, Role: invitation.Role}
+	err = addMembership(ctx, tx, membership)
+	if err != nil {
+		return nil, err
+	}
+	return membership, tx.Commit()
+}
+
+// DeleteExpired removes expired invitations in batches of batchSize, returning how many were deleted.
+func (m InvitationModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+    DELETE FROM invitations
+    WHERE id IN (
+        SELECT id FROM invitations
+        WHERE expiry < NOW()
+        LIMIT $1
+    )`
+
+	return deleteInBatches(m.DB, query, batchSize)
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// LoginPolicy decides when repeated failures turn into a lockout. Once MaxAttempts failures have piled up inside
+// Window, every further failure locks the key for Lockout, doubling each time up to MaxLockout.
+type LoginPolicy struct {
+	MaxAttempts int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+func (p LoginPolicy) lockoutFor(failures int) time.Duration {
+	if failures < p.MaxAttempts {
+		return 0
+	}
+	lockout := p.Lockout
+	for i := p.MaxAttempts; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, p.MaxLockout)
+}
+
+func UserLoginKey(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LockedUntil returns the latest lockout still in effect for any of the keys, or the zero time if none is.
+func (m LoginFailureModel) LockedUntil(keys ...string) (time.Time, error) {
+	query := `
+    SELECT COALESCE(MAX(locked_until), 'epoch')
+    FROM login_failures
+    WHERE key = ANY($1) AND locked_until > NOW()`
+
+	var lockedUntil time.Time
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, nil
+}
+
+// RecordFailure counts a failed login for key and returns the lockout it triggered, if any.
+func (m LoginFailureModel) RecordFailure(key string, policy LoginPolicy) (time.Time, error) {
+	query := `
+    INSERT INTO login_failures (key, failures, last_failure_at)
+    VALUES ($1, 1, NOW())
+    ON CONFLICT (key) DO UPDATE
+    SET failures = CASE
+            WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+            ELSE login_failures.failures + 1
+        END,
+        last_failure_at = NOW()
+    RETURNING failures`
+
+	var failures int
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, key, policy.Window.Seconds()).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockout := policy.lockoutFor(failures)
+	if lockout == 0 {
+		return time.Time{}, nil
+	}
+	lockedUntil := time.Now().Add(lockout)
+	_, err = m.DB.ExecContext(ctx, `UPDATE login_failures SET locked_until = $2 WHERE key = $1`, key, lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil, nil
+}
+
+// Reset forgets every failure recorded for the keys, lifting any lockout.
+func (m LoginFailureModel) Reset(keys ...string) error {
+	query := `
+    DELETE FROM login_failures
+    WHERE key = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(keys))
+	return err
+}
+
+// DeleteStale removes, in batches of batchSize, the failures last recorded longer than window ago whose lockout, if
+// any, is over. They would be reset by the next failure anyway.
+func (m LoginFailureModel) DeleteStale(window time.Duration, batchSize int) (int64, error) {
+	query := `
+    DELETE FROM login_failures
+    WHERE key IN (
+        SELECT key FROM login_failures
+        WHERE last_failure_at < NOW() - make_interval(secs => $2)
+        AND (locked_until IS NULL OR locked_until < NOW())
+        LIMIT $1
+    )`
+
+	return deleteInBatches(m.DB, query, batchSize, window.Seconds())
+}
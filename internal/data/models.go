@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -11,15 +13,61 @@ var (
 )
 
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
-	Tokens TokenModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	TOTP          TOTPModel
+	LoginFailures LoginFailureModel
+	APIKeys       APIKeyModel
+	Audit         AuditModel
+	Organizations OrganizationModel
+	Memberships   MembershipModel
+	Invitations   InvitationModel
+	Revisions     MovieRevisionModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
-		Tokens: TokenModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		TOTP:          TOTPModel{DB: db},
+		LoginFailures: LoginFailureModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		Audit:         AuditModel{DB: db},
+		Organizations: OrganizationModel{DB: db},
+		Memberships:   MembershipModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		Revisions:     MovieRevisionModel{DB: db},
+	}
+}
+
+// queryer runs statements either straight on the database or as part of a transaction, for the few statements that
+// are needed both ways.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// deleteInBatches runs a DELETE taking its batch size as $1 (plus any extra args) until a batch comes back short.
+func deleteInBatches(db *sql.DB, query string, batchSize int, args ...any) (int64, error) {
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		res, err := db.ExecContext(ctx, query, append([]any{batchSize}, args...)...)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += rows
+		if rows < int64(batchSize) {
+			return total, nil
+		}
 	}
 }
@@ -0,0 +1,270 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	MovieOpCreate = "create"
+	MovieOpUpdate = "update"
+	MovieOpDelete = "delete"
+)
+
+// MovieOp is one change of a batch. Movie holds the movie to insert, the updated movie, or the movie to delete.
+// ApplyBatch sets Err on the operations that failed.
+type MovieOp struct {
+	Op    string
+	Movie *Movie
+	Err   error
+}
+
+// GetMany returns the movies of orgID with the given IDs, keyed by ID. Missing IDs are left out.
+func (m MovieModel) GetMany(orgID int64, ids []int64) (map[int64]*Movie, error) {
+	query := `
+    SELECT id, created_at, organization_id, title, year, runtime, genres, created_by, deleted_at, version
+    FROM movies
+    WHERE organization_id = $1 AND id = ANY($2) AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make(map[int64]*Movie, len(ids))
+	for rows.Next() {
+		var movie Movie
+		args := []any{
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.OrganizationID,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.CreatedBy,
+			&movie.DeletedAt,
+			&movie.Version,
+		}
+		err := rows.Scan(args...)
+		if err != nil {
+			return nil, err
+		}
+		movies[movie.ID] = &movie
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// ApplyBatch saves ops of a single organization. Creates go out as one multi-row INSERT and deletes as one UPDATE,
+// updates one at a time since each of them records a revision. Updates and deletes only apply to the version of the
+// movie they carry.
+//
+// When atomic, everything runs in one transaction that is rolled back on the first failure. Failures specific to an
+// operation (ErrEditConflict, ErrRecordNotFound) are set on it, any other error is returned, and committed is false
+// when the batch was rolled back.
+//
+// Otherwise every step runs under a savepoint and a failure, whatever it is, is set on the operations it concerns
+// while the others go ahead. When the multi-row INSERT fails the creates are retried one by one, so only the ones
+// at fault are left out.
+func (m MovieModel) ApplyBatch(orgID int64, editorID int64, ops []*MovieOp, atomic bool) (committed bool, err error) {
+	var creates, deletes []*MovieOp
+	for _, op := range ops {
+		switch op.Op {
+		case MovieOpCreate:
+			creates = append(creates, op)
+		case MovieOpDelete:
+			deletes = append(deletes, op)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if atomic {
+		err = m.insertMany(ctx, tx, creates)
+		if err != nil {
+			return false, err
+		}
+		err = m.deleteMany(ctx, tx, orgID, deletes)
+		if err != nil {
+			return false, err
+		}
+		if failed(deletes) {
+			return false, nil
+		}
+		for _, op := range ops {
+			if op.Op != MovieOpUpdate {
+				continue
+			}
+			op.Err = m.update(ctx, tx, op.Movie, editorID)
+			switch {
+			case op.Err == nil:
+			case errors.Is(op.Err, ErrEditConflict):
+				return false, nil
+			default:
+				return false, op.Err
+			}
+		}
+		err = tx.Commit()
+		return err == nil, err
+	}
+
+	// NOTE: in best-effort mode a savepoint keeps one failed statement from aborting the whole transaction.
+	err = savepoint(ctx, tx, func() error { return m.insertMany(ctx, tx, creates) })
+	if err != nil && len(creates) > 1 {
+		for _, op := range creates {
+			op.Err = savepoint(ctx, tx, func() error { return m.insertMany(ctx, tx, []*MovieOp{op}) })
+		}
+	} else if err != nil {
+		creates[0].Err = err
+	}
+
+	err = savepoint(ctx, tx, func() error { return m.deleteMany(ctx, tx, orgID, deletes) })
+	if err != nil {
+		for _, op := range deletes {
+			op.Err = err
+		}
+	}
+
+	for _, op := range ops {
+		if op.Op == MovieOpUpdate {
+			op.Err = savepoint(ctx, tx, func() error { return m.update(ctx, tx, op.Movie, editorID) })
+		}
+	}
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// savepoint runs fn under a savepoint, rolling back to it when fn fails. fn's error is returned, or the error of
+// the savepoint itself, which leaves the transaction unusable.
+func savepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	_, err := tx.ExecContext(ctx, "SAVEPOINT movie_batch")
+	if err != nil {
+		return err
+	}
+	fnErr := fn()
+	if fnErr != nil {
+		_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT movie_batch")
+	} else {
+		_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT movie_batch")
+	}
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func failed(ops []*MovieOp) bool {
+	for _, op := range ops {
+		if op.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// insertMany inserts every movie of ops with a single statement.
+func (m MovieModel) insertMany(ctx context.Context, tx *sql.Tx, ops []*MovieOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(ops))
+	args := make([]any, 0, len(ops)*6)
+	for i, op := range ops {
+		n := i * 6
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		movie := op.Movie
+		args = append(args, movie.OrganizationID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy)
+	}
+	// NOTE: Postgres returns the rows of a multi-row INSERT ... VALUES in the order they were listed, which is what
+	// ties every returned ID back to its operation.
+	query := `
+    INSERT INTO movies (organization_id, title, year, runtime, genres, created_by)
+    VALUES ` + strings.Join(values, ", ") + `
+    RETURNING id, created_at, version`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	i := 0
+	for rows.Next() {
+		movie := ops[i].Movie
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+		i++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if i != len(ops) {
+		return fmt.Errorf("inserted %d movies out of %d", i, len(ops))
+	}
+	return nil
+}
+
+// deleteMany moves the movies of ops to the trash with a single statement, as long as they are still at the version
+// op.Movie carries. Movies that are gone or changed since get ErrEditConflict. IDs must be unique within ops.
+func (m MovieModel) deleteMany(ctx context.Context, tx *sql.Tx, orgID int64, ops []*MovieOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(ops))
+	versions := make([]int64, 0, len(ops))
+	byID := make(map[int64]*MovieOp, len(ops))
+	for _, op := range ops {
+		ids = append(ids, op.Movie.ID)
+		versions = append(versions, int64(op.Movie.Version))
+		byID[op.Movie.ID] = op
+		op.Err = ErrEditConflict
+	}
+	query := `
+    UPDATE movies
+    SET deleted_at = NOW(), version = movies.version + 1
+    FROM unnest($2::bigint[], $3::integer[]) AS expected(id, version)
+    WHERE movies.organization_id = $1 AND movies.id = expected.id AND movies.version = expected.version
+    AND movies.deleted_at IS NULL
+    RETURNING movies.id, movies.deleted_at, movies.version`
+
+	rows, err := tx.QueryContext(ctx, query, orgID, pq.Array(ids), pq.Array(versions))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var deletedAt time.Time
+		var version int32
+		err := rows.Scan(&id, &deletedAt, &version)
+		if err != nil {
+			return err
+		}
+		op := byID[id]
+		op.Movie.DeletedAt = &deletedAt
+		op.Movie.Version = version
+		op.Err = nil
+	}
+	return rows.Err()
+}
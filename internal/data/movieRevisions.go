@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// MovieRevision is a movie as it was at Version, saved when an edit replaced it. EditedBy is the user who made that
+// edit.
+type MovieRevision struct {
+	MovieID    int64     `json:"movie_id"`
+	Version    int32     `json:"version"`
+	Title      string    `json:"title"`
+	Year       int32     `json:"year,omitempty"`
+	Runtime    Runtime   `json:"runtime,omitempty"`
+	Genres     []string  `json:"genres,omitempty"`
+	CreatedBy  int64     `json:"created_by"`
+	EditedBy   *int64    `json:"edited_by"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Apply copies the content of the revision onto movie, leaving its identity and version alone.
+func (rev *MovieRevision) Apply(movie *Movie) {
+	movie.Title = rev.Title
+	movie.Year = rev.Year
+	movie.Runtime = rev.Runtime
+	movie.Genres = rev.Genres
+}
+
+// GetAllForMovie returns the revisions of a movie, newest first. Callers check movieID belongs to the organization.
+func (m MovieRevisionModel) GetAllForMovie(movieID int64) ([]*MovieRevision, error) {
+	query := `
+    SELECT movie_id, version, title, year, runtime, genres, created_by, edited_by, replaced_at
+    FROM movie_revisions
+    WHERE movie_id = $1
+    ORDER BY version DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var rev MovieRevision
+		err := rows.Scan(
+			&rev.MovieID,
+			&rev.Version,
+			&rev.Title,
+			&rev.Year,
+			&rev.Runtime,
+			pq.Array(&rev.Genres),
+			&rev.CreatedBy,
+			&rev.EditedBy,
+			&rev.ReplacedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+    SELECT movie_id, version, title, year, runtime, genres, created_by, edited_by, replaced_at
+    FROM movie_revisions
+    WHERE movie_id = $1 AND version = $2`
+
+	var rev MovieRevision
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&rev.MovieID,
+		&rev.Version,
+		&rev.Title,
+		&rev.Year,
+		&rev.Runtime,
+		pq.Array(&rev.Genres),
+		&rev.CreatedBy,
+		&rev.EditedBy,
+		&rev.ReplacedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &rev, nil
+}
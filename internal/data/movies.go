@@ -15,14 +15,20 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
+// Movie belongs to a single organization. Every MovieModel query is scoped by organization ID, so a movie can't be
+// reached from outside its organization even with a known ID. Deleted movies stay in the trash until purged, and are
+// invisible to everything but the trash methods.
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"-"`
+	OrganizationID int64      `json:"organization_id"`
+	Title          string     `json:"title"`
+	Year           int32      `json:"year,omitempty"`
+	Runtime        Runtime    `json:"runtime,omitempty"`
+	Genres         []string   `json:"genres,omitempty"`
+	CreatedBy      int64      `json:"created_by"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	Version        int32      `json:"version"`
 }
 
 func (movie *Movie) Validate(v *validator.Validator) {
@@ -41,29 +47,38 @@ func (movie *Movie) Validate(v *validator.Validator) {
 
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-    INSERT INTO movies (title, year, runtime, genres)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO movies (organization_id, title, year, runtime, genres, created_by)
+    VALUES ($1, $2, $3, $4, $5, $6)
     RETURNING id, created_at, version
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.OrganizationID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy}
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(orgID int64, id int64) (*Movie, error) {
+	return m.get(orgID, id, false)
+}
+
+// GetDeleted is Get for movies in the trash.
+func (m MovieModel) GetDeleted(orgID int64, id int64) (*Movie, error) {
+	return m.get(orgID, id, true)
+}
+
+func (m MovieModel) get(orgID int64, id int64, deleted bool) (*Movie, error) {
 	query := `
-    SELECT id, created_at, title, year, runtime, genres, version
+    SELECT id, created_at, organization_id, title, year, runtime, genres, created_by, deleted_at, version
     FROM movies
-    WHERE id = $1
+    WHERE id = $1 AND organization_id = $2 AND (deleted_at IS NOT NULL) = $3
     `
 
 	var movie Movie
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []any{&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version}
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(args...)
+	args := []any{&movie.ID, &movie.CreatedAt, &movie.OrganizationID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.CreatedBy, &movie.DeletedAt, &movie.Version}
+	err := m.DB.QueryRowContext(ctx, query, id, orgID, deleted).Scan(args...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -75,17 +90,104 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update saves movie, keeping a snapshot of the version it replaces in movie_revisions. editorID is the user making
+// the change.
+func (m MovieModel) Update(movie *Movie, editorID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.update(ctx, tx, movie, editorID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m MovieModel) update(ctx context.Context, tx *sql.Tx, movie *Movie, editorID int64) error {
+	// NOTE: two concurrent edits of the same version collide on the revisions primary key, the loser gets an edit
+	// conflict just like it would from the version check below.
+	query := `
+    INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, created_by, edited_by)
+    SELECT id, version, title, year, runtime, genres, created_by, $4
+    FROM movies
+    WHERE id = $1 AND organization_id = $2 AND version = $3 AND deleted_at IS NULL
+    `
+	res, err := tx.ExecContext(ctx, query, movie.ID, movie.OrganizationID, movie.Version, editorID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_revisions_pkey"`:
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	query = `
+    UPDATE movies
+    SET title = $1, year = $2, runtime = $3, genres = $4, created_by = $5, version = version + 1
+    WHERE id = $6 AND organization_id = $7 AND version = $8 AND deleted_at IS NULL
+    RETURNING version
+    `
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy, movie.ID, movie.OrganizationID, movie.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete moves a movie to the trash.
+func (m MovieModel) Delete(orgID int64, id int64) error {
+	query := `
+    UPDATE movies
+    SET deleted_at = NOW(), version = version + 1
+    WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Restore takes a movie out of the trash.
+func (m MovieModel) Restore(movie *Movie) error {
 	query := `
     UPDATE movies
-    SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-    WHERE id = $5 AND version = $6
+    SET deleted_at = NULL, version = version + 1
+    WHERE id = $1 AND organization_id = $2 AND version = $3 AND deleted_at IS NOT NULL
     RETURNING version
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, movie.ID, movie.OrganizationID, movie.Version).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -94,18 +196,20 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
+	movie.DeletedAt = nil
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Purge permanently removes a movie from the trash.
+func (m MovieModel) Purge(orgID int64, id int64) error {
 	query := `
-    DELETE FROM MOVIES
-    WHERE id = $1
+    DELETE FROM movies
+    WHERE id = $1 AND organization_id = $2 AND deleted_at IS NOT NULL
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	res, err := m.DB.ExecContext(ctx, query, id)
+	res, err := m.DB.ExecContext(ctx, query, id, orgID)
 	if err != nil {
 		return err
 	}
@@ -119,18 +223,86 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) List(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// DeleteTrashed purges movies of every organization that have been in the trash for longer than retention.
+func (m MovieModel) DeleteTrashed(retention time.Duration, batchSize int) (int64, error) {
+	query := `
+    DELETE FROM movies
+    WHERE id IN (
+        SELECT id FROM movies
+        WHERE deleted_at < NOW() - make_interval(secs => $2)
+        LIMIT $1
+    )`
+
+	return deleteInBatches(m.DB, query, batchSize, retention.Seconds())
+}
+
+// GetAllCreatedBy returns the movies userID created in every organization, including the ones in the trash.
+func (m MovieModel) GetAllCreatedBy(userID int64) ([]*Movie, error) {
+	query := `
+    SELECT id, created_at, organization_id, title, year, runtime, genres, created_by, deleted_at, version
+    FROM movies
+    WHERE created_by = $1
+    ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		args := []any{
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.OrganizationID,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.CreatedBy,
+			&movie.DeletedAt,
+			&movie.Version,
+		}
+		err := rows.Scan(args...)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// List returns the movies of orgID matching title and genres, ownerID narrows it down to a single user's movies unless it is 0.
+func (m MovieModel) List(orgID int64, title string, genres []string, ownerID int64, filters Filters) ([]*Movie, Metadata, error) {
+	return m.list(orgID, title, genres, ownerID, false, filters)
+}
+
+// ListDeleted returns the trash of orgID.
+func (m MovieModel) ListDeleted(orgID int64, filters Filters) ([]*Movie, Metadata, error) {
+	return m.list(orgID, "", []string{}, 0, true, filters)
+}
+
+func (m MovieModel) list(orgID int64, title string, genres []string, ownerID int64, deleted bool, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-    SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+    SELECT count(*) OVER(), id, created_at, organization_id, title, year, runtime, genres, created_by, deleted_at, version
     FROM movies
-    WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-    AND (genres @> $2 OR $2 = '{}')
+    WHERE organization_id = $1
+    AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+    AND (genres @> $3 OR $3 = '{}')
+    AND ($4 = 0 OR created_by = $4)
+    AND (deleted_at IS NOT NULL) = $5
     ORDER BY %s %s, id ASC
-    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+    LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{orgID, title, pq.Array(genres), ownerID, deleted, filters.limit(), filters.offset()}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -144,10 +316,13 @@ func (m MovieModel) List(title string, genres []string, filters Filters) ([]*Mov
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.OrganizationID,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.CreatedBy,
+			&movie.DeletedAt,
 			&movie.Version,
 		}
 		err := rows.Scan(args...)
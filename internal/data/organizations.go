@@ -0,0 +1,311 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+var ErrDuplicateSlug = errors.New("duplicate slug")
+
+// DefaultOrganizationSlug names the organization holding the catalog from before organizations existed. New users
+// join it as viewers.
+const DefaultOrganizationSlug = "default"
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var SlugRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Role      string    `json:"role,omitempty"`
+	Version   int32     `json:"-"`
+}
+
+func (org *Organization) Validate(v *validator.Validator) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(validator.Matches(org.Slug, SlugRX), "slug", "must be 2 to 63 lowercase letters, digits or dashes")
+}
+
+// Membership ties a user to an organization with a role.
+type Membership struct {
+	OrganizationID int64     `json:"organization_id"`
+	UserID         int64     `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, RoleOwner, RoleEditor, RoleViewer), "role", "must be owner, editor or viewer")
+}
+
+// Insert creates org with owner as its first owner.
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+    INSERT INTO organizations (name, slug)
+    VALUES ($1, $2)
+    RETURNING id, created_at, version`
+	err = tx.QueryRowContext(ctx, query, org.Name, org.Slug).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	query = `
+    INSERT INTO memberships (organization_id, user_id, role)
+    VALUES ($1, $2, $3)`
+	_, err = tx.ExecContext(ctx, query, org.ID, ownerID, RoleOwner)
+	if err != nil {
+		return err
+	}
+	org.Role = RoleOwner
+	return tx.Commit()
+}
+
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+	query := `
+    SELECT id, created_at, name, slug, version
+    FROM organizations
+    WHERE id = $1`
+
+	var org Organization
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+    SELECT id, created_at, name, slug, version
+    FROM organizations
+    WHERE slug = $1`
+
+	var org Organization
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+// GetAllForUser returns the organizations userID is a member of, with their role in each.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+    SELECT organizations.id, organizations.created_at, organizations.name, organizations.slug, memberships.role, organizations.version
+    FROM organizations
+    INNER JOIN memberships ON memberships.organization_id = organizations.id
+    WHERE memberships.user_id = $1
+    ORDER BY organizations.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+	for rows.Next() {
+		var org Organization
+		err := rows.Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Role, &org.Version)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+type MembershipModel struct {
+	DB *sql.DB
+}
+
+func (m MembershipModel) Get(orgID int64, userID int64) (*Membership, error) {
+	query := `
+    SELECT organization_id, user_id, role, created_at
+    FROM memberships
+    WHERE organization_id = $1 AND user_id = $2`
+
+	var membership Membership
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID).Scan(
+		&membership.OrganizationID,
+		&membership.UserID,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &membership, nil
+}
+
+func (m MembershipModel) GetAllForOrganization(orgID int64) ([]*Membership, error) {
+	query := `
+    SELECT organization_id, user_id, role, created_at
+    FROM memberships
+    WHERE organization_id = $1
+    ORDER BY created_at, user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*Membership{}
+	for rows.Next() {
+		var membership Membership
+		err := rows.Scan(&membership.OrganizationID, &membership.UserID, &membership.Role, &membership.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, &membership)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// addMembership adds the user to the organization. A user who already is a member keeps their role, which is set on
+// membership along with when they joined.
+func addMembership(ctx context.Context, q queryer, membership *Membership) error {
+	query := `
+    INSERT INTO memberships (organization_id, user_id, role)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (organization_id, user_id) DO UPDATE SET role = memberships.role
+    RETURNING role, created_at`
+
+	return q.QueryRowContext(ctx, query, membership.OrganizationID, membership.UserID, membership.Role).Scan(&membership.Role, &membership.CreatedAt)
+}
+
+// UpdateRole gives an existing member membership.Role, returning ErrRecordNotFound when the user isn't a member.
+func (m MembershipModel) UpdateRole(membership *Membership) error {
+	query := `
+    UPDATE memberships
+    SET role = $3
+    WHERE organization_id = $1 AND user_id = $2
+    RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, membership.OrganizationID, membership.UserID, membership.Role).Scan(&membership.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// AddToDefault makes userID a viewer of the default organization.
+func (m MembershipModel) AddToDefault(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return addToDefaultOrganization(ctx, m.DB, userID)
+}
+
+func addToDefaultOrganization(ctx context.Context, q queryer, userID int64) error {
+	query := `
+    INSERT INTO memberships (organization_id, user_id, role)
+    SELECT id, $1, $2 FROM organizations WHERE slug = $3
+    ON CONFLICT DO NOTHING`
+
+	_, err := q.ExecContext(ctx, query, userID, RoleViewer, DefaultOrganizationSlug)
+	return err
+}
+
+func (m MembershipModel) Delete(orgID int64, userID int64) error {
+	query := `
+    DELETE FROM memberships
+    WHERE organization_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountOwners is used to keep organizations from losing their last owner.
+func (m MembershipModel) CountOwners(orgID int64) (int, error) {
+	query := `
+    SELECT count(*)
+    FROM memberships
+    WHERE organization_id = $1 AND role = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, orgID, RoleOwner).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+// commonPasswords is a gzipped, newline separated list of the passwords that show up most often in public breaches.
+//
+//go:embed passwords/common.txt.gz
+var commonPasswordsGz []byte
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]struct{}
+)
+
+// PasswordPolicy holds the rules new passwords must follow on top of the length limits in ValidatePasswordPlaintext.
+type PasswordPolicy struct {
+	MinEntropy    float64
+	CheckBreached bool
+}
+
+// DefaultPasswordPolicy is applied at registration and password reset. It is replaced at startup with the one
+// configured through flags.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinEntropy:    40,
+	CheckBreached: true,
+}
+
+func (p PasswordPolicy) Validate(v *validator.Validator, password string, usr *User) {
+	lowered := strings.ToLower(password)
+	for _, part := range strings.Fields(strings.ToLower(usr.Name)) {
+		if len(part) >= 3 && strings.Contains(lowered, part) {
+			v.AddError("password", "must not contain your name")
+			return
+		}
+	}
+	if local, _, found := strings.Cut(strings.ToLower(usr.Email), "@"); found && len(local) >= 3 && strings.Contains(lowered, local) {
+		v.AddError("password", "must not contain your email address")
+		return
+	}
+	if p.CheckBreached && isCommonPassword(lowered) {
+		v.AddError("password", "is too common, it appears in lists of leaked passwords")
+		return
+	}
+	if entropy := validator.Entropy(password); entropy < p.MinEntropy {
+		v.AddError("password", fmt.Sprintf("is too weak: estimated strength is %.0f bits but %.0f are required, use a longer password or mix letters, digits and symbols", entropy, p.MinEntropy))
+	}
+}
+
+func isCommonPassword(lowered string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]struct{})
+		gz, err := gzip.NewReader(bytes.NewReader(commonPasswordsGz))
+		if err != nil {
+			panic(err)
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			commonPasswords[strings.ToLower(scanner.Text())] = struct{}{}
+		}
+		if err := scanner.Err(); err != nil {
+			panic(err)
+		}
+	})
+	_, found := commonPasswords[lowered]
+	return found
+}
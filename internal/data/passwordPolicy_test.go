@@ -0,0 +1,41 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/PedroDrago/greenlight/internal/validator"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinEntropy: 40, CheckBreached: true}
+	alice := &User{Name: "Alice Liddell", Email: "wonderland@example.com"}
+
+	tests := []struct {
+		name     string
+		usr      *User
+		password string
+		want     string
+	}{
+		{"passphrase", alice, "purple monkey dishwasher tango", ""},
+		{"letters, digits and symbols", alice, "T4k3-m3-h0me!", ""},
+		{"too short", alice, "Ab1!x", "must be at least 8 bytes long"},
+		{"first name", alice, "alice-in-chains-2024", "must not contain your name"},
+		{"last name in capitals", alice, "LIDDELL!forever99", "must not contain your name"},
+		{"email local part", alice, "my-Wonderland-pass-77", "must not contain your email address"},
+		{"short name parts are ignored", &User{Name: "Al Bo", Email: "ab@example.com"}, "al-bo-jumps-over-87", ""},
+		{"common", alice, "Password123", "is too common, it appears in lists of leaked passwords"},
+		{"low entropy", alice, "zxcvbnml", "is too weak: estimated strength is 38 bits but 40 are required, use a longer password or mix letters, digits and symbols"},
+		{"long but repetitive", alice, "aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbb", "is too weak: estimated strength is 9 bits but 40 are required, use a longer password or mix letters, digits and symbols"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidatePasswordPlaintext(v, tt.password)
+			policy.Validate(v, tt.password, tt.usr)
+			if got := v.Errors["password"]; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
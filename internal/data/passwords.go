@@ -0,0 +1,153 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// PasswordHasher turns plaintext passwords into self-describing hashes. Matches must accept any hash the hasher
+// produces, and NeedsRehash reports hashes that were made by another algorithm or with other parameters.
+type PasswordHasher interface {
+	Hash(plaintext string) ([]byte, error)
+	Matches(hash []byte, plaintext string) (bool, error)
+	NeedsRehash(hash []byte) bool
+	MaxLength() int
+}
+
+// DefaultPasswordHasher hashes every new password. It is replaced at startup with the one configured through flags.
+var DefaultPasswordHasher PasswordHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// hasherFor picks the hasher able to verify hash, whatever the current default is.
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		return Argon2idHasher{}, nil
+	case bytes.HasPrefix(hash, []byte("$2")):
+		return BcryptHasher{}, nil
+	default:
+		return nil, ErrInvalidHash
+	}
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// MaxLength is 72 bytes because bcrypt ignores anything after that.
+func (h BcryptHasher) MaxLength() int {
+	return 72
+}
+
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Iterations,
+		h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+// Matches reads the parameters from the hash itself, so it doesn't matter how h is configured.
+func (h Argon2idHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params != h
+}
+
+func (h Argon2idHasher) MaxLength() int {
+	return 1024
+}
+
+func decodeArgon2id(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idHasher{}, nil, nil, ErrInvalidHash
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2idHasher{}, nil, nil, ErrInvalidHash
+	}
+	var params Argon2idHasher
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, ErrInvalidHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
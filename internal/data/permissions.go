@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+    SELECT permissions.code
+    FROM permissions
+    INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+    INNER JOIN users ON users_permissions.user_id = users.id
+    WHERE users.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return addPermissions(ctx, m.DB, userID, codes...)
+}
+
+func addPermissions(ctx context.Context, q queryer, userID int64, codes ...string) error {
+	query := `
+    INSERT INTO users_permissions
+    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING`
+
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+    SELECT code
+    FROM permissions
+    ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+    DELETE FROM users_permissions
+    WHERE user_id = $1
+    AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
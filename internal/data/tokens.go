@@ -12,20 +12,35 @@ import (
 	"github.com/PedroDrago/greenlight/internal/validator"
 )
 
+var ErrTokenReused = errors.New("token reused")
+
 type TokenModel struct {
 	DB *sql.DB
 }
 
 type Token struct {
-	Hash      []byte
-	PlainText string
-	UserID    int64
-	Expiry    time.Time
-	Scope     string
+	ID         int64      `json:"id,omitempty"`
+	Hash       []byte     `json:"-"`
+	PlainText  string     `json:"token,omitempty"`
+	UserID     int64      `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"scope"`
+	Family     []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
 }
 
 const (
-	ScopeActivation = "activation"
+	ScopeActivation        = "activation"
+	ScopeAuthentication    = "authentication"
+	ScopePasswordReset     = "password-reset"
+	ScopeRefresh           = "refresh"
+	ScopeEmailChange       = "email-change"
+	ScopeEmailChangeCancel = "email-change-cancel"
+	ScopeDeletionCancel    = "deletion-cancel"
+	ScopeMagicLink         = "magic-link"
 )
 
 func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -47,6 +62,17 @@ func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// NewTokenFamily returns a random identifier shared by every token issued from the same login, so the whole chain of
+// refresh tokens (and the access tokens issued alongside them) can be revoked at once.
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -65,17 +91,76 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewSession creates a token that belongs to a login session, recording which client it was issued to.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, scope string, family []byte, userAgent string, ip string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+	token.UserAgent = userAgent
+	token.IP = ip
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m *TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, q queryer, token *Token) error {
+	query := `
+    INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent, ip)
+    VALUES  ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id, created_at`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, nullBytes(token.Family), token.UserAgent, token.IP}
+	return q.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Touch records that an authentication token was just used. It writes at most once a minute per token so busy
+// clients don't turn every request into an UPDATE.
+func (m TokenModel) Touch(tokenPlainText string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 	query := `
-    INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES  ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+    UPDATE tokens
+    SET last_used_at = NOW()
+    WHERE hash = $1
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
 	return err
 }
 
+// DeleteForUser revokes a single session token, along with every other token issued from the same login. Single-use
+// tokens such as password resets are not sessions and cannot be revoked through it.
+func (m TokenModel) DeleteForUser(id int64, userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE user_id = $2
+    AND scope IN ('authentication', 'refresh')
+    AND (id = $1 OR family = (
+        SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope IN ('authentication', 'refresh')))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
     DELETE FROM tokens
@@ -87,11 +172,62 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// Consume deletes a single-use token, returning ErrRecordNotFound when it is already gone or expired. Only one of
+// several concurrent requests presenting the same token can succeed.
+func (m TokenModel) Consume(scope string, tokenPlainText string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	query := `
+    DELETE FROM tokens
+    WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope, time.Now())
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeAllForUser deletes every token the user holds, whatever its scope.
+func (m TokenModel) RevokeAllForUser(userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired removes expired tokens in batches of batchSize, so a large backlog never holds a long lock on the
+// table. It returns how many tokens were deleted.
+func (m TokenModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+    DELETE FROM tokens
+    WHERE hash IN (SELECT hash FROM tokens WHERE expiry < NOW() LIMIT $1)`
+
+	return deleteInBatches(m.DB, query, batchSize)
+}
+
+// GetAllForUser lists the user's live sessions, that is their authentication and refresh tokens.
 func (m TokenModel) GetAllForUser(usr *User) ([]Token, error) {
 	query := `
-    SELECT hash, user_id, expiry, scope
+    SELECT id, hash, user_id, expiry, scope, created_at, last_used_at, user_agent, ip
     FROM tokens
-    WHERE user_id = $1`
+    WHERE user_id = $1
+    AND scope IN ('authentication', 'refresh')
+    AND expiry > NOW()
+    AND used_at IS NULL
+    ORDER BY created_at DESC, id DESC`
 
 	var tokens []Token
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -109,7 +245,18 @@ func (m TokenModel) GetAllForUser(usr *User) ([]Token, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var token Token
-		err := rows.Scan(&token.Hash, &token.UserID, &token.Expiry, &token.Scope)
+		args := []any{
+			&token.ID,
+			&token.Hash,
+			&token.UserID,
+			&token.Expiry,
+			&token.Scope,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.UserAgent,
+			&token.IP,
+		}
+		err := rows.Scan(args...)
 		if err != nil {
 			return nil, err
 		}
@@ -120,3 +267,85 @@ func (m TokenModel) GetAllForUser(usr *User) ([]Token, error) {
 	}
 	return tokens, nil
 }
+
+// Rotate exchanges a refresh token for a new one in the same family. A refresh token can only be used once: presenting
+// it again revokes every token in its family and returns ErrTokenReused along with the offending token, so the caller
+// can report who it belonged to.
+func (m TokenModel) Rotate(tokenPlainText string, ttl time.Duration, userAgent string, ip string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+    SELECT user_id, expiry, family, used_at
+    FROM tokens
+    WHERE hash = $1 AND scope = $2
+    FOR UPDATE`
+
+	old := Token{Hash: tokenHash[:], Scope: ScopeRefresh}
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, old.Hash, ScopeRefresh).Scan(&old.UserID, &old.Expiry, &old.Family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, old.Family)
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &old, ErrTokenReused
+	}
+	if time.Now().After(old.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, old.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := GenerateToken(old.UserID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = old.Family
+	token.UserAgent = userAgent
+	token.IP = ip
+	query = `
+    INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent, ip)
+    VALUES  ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id, created_at`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, nullBytes(token.Family), token.UserAgent, token.IP}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// nullBytes maps an empty slice to NULL, pq would otherwise store it as an empty bytea.
+func nullBytes(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
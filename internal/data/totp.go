@@ -0,0 +1,198 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// GenerateRecoveryCodes returns single-use codes for users who lost their authenticator. Like tokens, only the
+// SHA-256 hash of each code is ever stored.
+func GenerateRecoveryCodes() (plaintext []string, hashes [][]byte, err error) {
+	for range recoveryCodeCount {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+		hash := sha256.Sum256([]byte(code))
+		plaintext = append(plaintext, code)
+		hashes = append(hashes, hash[:])
+	}
+	return plaintext, hashes, nil
+}
+
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+    SELECT user_id, secret, confirmed, last_used_step
+    FROM totp
+    WHERE user_id = $1`
+
+	var t TOTP
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.Secret, &t.Confirmed, &t.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// SetPending stores a secret that still has to be confirmed with a first code. It replaces any earlier unconfirmed
+// secret, but never a confirmed one.
+func (m TOTPModel) SetPending(userID int64, secret string) error {
+	query := `
+    INSERT INTO totp (user_id, secret)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE
+    SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+    WHERE totp.confirmed = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Confirm enables two-factor authentication for the user and replaces their recovery codes.
+func (m TOTPModel) Confirm(userID int64, step int64, recoveryCodeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+    UPDATE totp
+    SET confirmed = true, last_used_step = $2
+    WHERE user_id = $1 AND confirmed = false`
+	res, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO totp_recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the code for step was accepted. It reports false if that step, or a later one, was already
+// used, so a code seen over someone's shoulder can't be replayed.
+func (m TOTPModel) UseStep(userID int64, step int64) (bool, error) {
+	query := `
+    UPDATE totp
+    SET last_used_step = $2
+    WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// UseRecoveryCode consumes a recovery code, reporting whether it was valid.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(code))
+	query := `
+    DELETE FROM totp_recovery_codes
+    WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Delete turns two-factor authentication off for the user, discarding their secret and any unused recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -4,26 +4,57 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/PedroDrago/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrDuplicateEmail = errors.New("duplicate email")
 
+var AnonymousUser = &User{}
+
 type UserModel struct {
 	DB *sql.DB
 }
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int32     `json:"-"`
+	ID                  int64       `json:"id"`
+	CreatedAt           time.Time   `json:"created_at"`
+	Name                string      `json:"name"`
+	Email               string      `json:"email"`
+	PendingEmail        *string     `json:"pending_email,omitempty"`
+	Preferences         Preferences `json:"preferences"`
+	Password            password    `json:"-"`
+	Activated           bool        `json:"activated"`
+	DeactivatedAt       *time.Time  `json:"deactivated_at,omitempty"`
+	DeletionScheduledAt *time.Time  `json:"deletion_scheduled_at,omitempty"`
+	Version             int32       `json:"-"`
+}
+
+func (usr *User) IsAnonymous() bool {
+	return usr == AnonymousUser
+}
+
+// Preferences is a free-form JSON object clients can use to store per-user settings.
+type Preferences map[string]any
+
+func (p Preferences) Value() (driver.Value, error) {
+	if p == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *Preferences) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Preferences", src)
+	}
+	return json.Unmarshal(b, p)
 }
 
 type password struct {
@@ -32,7 +63,7 @@ type password struct {
 }
 
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), bcrypt.DefaultCost)
+	hash, err := DefaultPasswordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -41,17 +72,28 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// Clear leaves the user without a password, so nothing matches until a new one is set through a password reset.
+func (p *password) Clear() {
+	p.plaintext = nil
+	p.hash = []byte{}
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	// NOTE: the system account has no password at all, nothing matches it.
+	if len(p.hash) == 0 {
+		return false, nil
+	}
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
-	return true, nil
+	return hasher.Matches(p.hash, plaintextPassword)
+}
+
+// NeedsRehash reports whether the stored hash was made with an older algorithm or older parameters than the ones
+// DefaultPasswordHasher uses now.
+func (p *password) NeedsRehash() bool {
+	return DefaultPasswordHasher.NeedsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -63,15 +105,18 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must not be empty")
 	passwdLen := len(password)
 	v.Check(passwdLen >= 8, "password", "must be at least 8 bytes long")
-	v.Check(passwdLen <= 72, "password", "must not be more than 72 bytes long")
+	maxLen := DefaultPasswordHasher.MaxLength()
+	v.Check(passwdLen <= maxLen, "password", fmt.Sprintf("must not be more than %d bytes long", maxLen))
 }
 
 func (usr *User) Validate(v *validator.Validator) {
 	v.Check(usr.Name != "", "name", "must be provided")
 	v.Check(len(usr.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(usr.Preferences) <= 50, "preferences", "must not contain more than 50 keys")
 	ValidateEmail(v, usr.Email)
 	if usr.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *usr.Password.plaintext)
+		DefaultPasswordPolicy.Validate(v, *usr.Password.plaintext, usr)
 	}
 	if usr.Password.hash == nil {
 		panic("missing password hash for user")
@@ -79,15 +124,58 @@ func (usr *User) Validate(v *validator.Validator) {
 }
 
 func (m *UserModel) Insert(usr *User) error {
-	query := `
-    INSERT INTO users (name, email, password_hash, activated)
-    VALUES ($1, $2, $3, $4)
-    RETURNING id, created_at, version
-    `
-	args := []any{usr.Name, usr.Email, usr.Password.hash, usr.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&usr.ID, &usr.CreatedAt, &usr.Version)
+	return insertUser(ctx, m.DB, usr)
+}
+
+// Register inserts usr with the permissions and default organization every new user gets, along with an activation
+// token valid for activationTTL, all in a single transaction.
+func (m *UserModel) Register(usr *User, activationTTL time.Duration) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = registerUser(ctx, tx, usr)
+	if err != nil {
+		return nil, err
+	}
+	token, err := GenerateToken(usr.ID, activationTTL, ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, tx.Commit()
+}
+
+// registerUser inserts usr and gives them what every new user starts out with.
+func registerUser(ctx context.Context, tx *sql.Tx, usr *User) error {
+	err := insertUser(ctx, tx, usr)
+	if err != nil {
+		return err
+	}
+	err = addPermissions(ctx, tx, usr.ID, "movies:read")
+	if err != nil {
+		return err
+	}
+	return addToDefaultOrganization(ctx, tx, usr.ID)
+}
+
+func insertUser(ctx context.Context, q queryer, usr *User) error {
+	query := `
+    INSERT INTO users (name, email, password_hash, activated, preferences)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING id, created_at, preferences, version
+    `
+	args := []any{usr.Name, usr.Email, usr.Password.hash, usr.Activated, usr.Preferences}
+	err := q.QueryRowContext(ctx, query, args...).Scan(&usr.ID, &usr.CreatedAt, &usr.Preferences, &usr.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -102,16 +190,20 @@ func (m *UserModel) Insert(usr *User) error {
 func (m *UserModel) Update(usr *User) error {
 	query := `
     UPDATE users
-    SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-    WHERE id = $5 AND version = $6
+    SET name = $1, email = $2, pending_email = $3, preferences = $4, password_hash = $5, activated = $6, deactivated_at = $7, deletion_scheduled_at = $8, version = version + 1
+    WHERE id = $9 AND version = $10
     RETURNING version
     `
 
 	args := []any{
 		usr.Name,
 		usr.Email,
+		usr.PendingEmail,
+		usr.Preferences,
 		usr.Password.hash,
 		usr.Activated,
+		usr.DeactivatedAt,
+		usr.DeletionScheduledAt,
 		usr.ID,
 		usr.Version,
 	}
@@ -124,7 +216,7 @@ func (m *UserModel) Update(usr *User) error {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -133,9 +225,43 @@ func (m *UserModel) Update(usr *User) error {
 	return nil
 }
 
+func (m *UserModel) Get(id int64) (*User, error) {
+	query := `
+    SELECT id, created_at, name, email, pending_email, preferences, password_hash, activated, deactivated_at, deletion_scheduled_at, version
+    FROM users
+    WHERE id = $1
+    `
+
+	var usr User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&usr.ID,
+		&usr.CreatedAt,
+		&usr.Name,
+		&usr.Email,
+		&usr.PendingEmail,
+		&usr.Preferences,
+		&usr.Password.hash,
+		&usr.Activated,
+		&usr.DeactivatedAt,
+		&usr.DeletionScheduledAt,
+		&usr.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &usr, nil
+}
+
 func (m *UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-    SELECT id, created_at, name, email. password_hash, activated, version
+    SELECT id, created_at, name, email, pending_email, preferences, password_hash, activated, deactivated_at, deletion_scheduled_at, version
     FROM users
     WHERE email = $1
     `
@@ -148,8 +274,12 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 		&usr.CreatedAt,
 		&usr.Name,
 		&usr.Email,
+		&usr.PendingEmail,
+		&usr.Preferences,
 		&usr.Password.hash,
 		&usr.Activated,
+		&usr.DeactivatedAt,
+		&usr.DeletionScheduledAt,
 		&usr.Version,
 	)
 	if err != nil {
@@ -166,7 +296,7 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 func (m *UserModel) GetForToken(scope string, tokenPlainText string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 	query := `
-    SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+    SELECT users.id, users.created_at, users.name, users.email, users.pending_email, users.preferences, users.password_hash, users.activated, users.deactivated_at, users.deletion_scheduled_at, users.version
     FROM users
     INNER JOIN tokens
     ON users.id = tokens.user_id
@@ -184,8 +314,12 @@ func (m *UserModel) GetForToken(scope string, tokenPlainText string) (*User, err
 		&usr.CreatedAt,
 		&usr.Name,
 		&usr.Email,
+		&usr.PendingEmail,
+		&usr.Preferences,
 		&usr.Password.hash,
 		&usr.Activated,
+		&usr.DeactivatedAt,
+		&usr.DeletionScheduledAt,
 		&usr.Version,
 	)
 	if err != nil {
@@ -198,3 +332,84 @@ func (m *UserModel) GetForToken(scope string, tokenPlainText string) (*User, err
 	}
 	return &usr, nil
 }
+
+// List is meant for support staff, search matches anywhere in the name or email address.
+func (m *UserModel) List(search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, created_at, name, email, pending_email, preferences, password_hash, activated, deactivated_at, deletion_scheduled_at, version
+    FROM users
+    WHERE ($1 = '' OR name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%')
+    AND ($2::boolean IS NULL OR activated = $2)
+    ORDER BY %s %s, id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	search = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+	args := []any{search, activated, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var usr User
+		args := []any{
+			&totalRecords,
+			&usr.ID,
+			&usr.CreatedAt,
+			&usr.Name,
+			&usr.Email,
+			&usr.PendingEmail,
+			&usr.Preferences,
+			&usr.Password.hash,
+			&usr.Activated,
+			&usr.DeactivatedAt,
+			&usr.DeletionScheduledAt,
+			&usr.Version,
+		}
+		err := rows.Scan(args...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &usr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// DeleteUnactivated purges accounts that were never activated within retention, freeing their email addresses.
+// Accounts deactivated by an admin are kept.
+func (m *UserModel) DeleteUnactivated(retention time.Duration, batchSize int) (int64, error) {
+	query := `
+    DELETE FROM users
+    WHERE id IN (
+        SELECT id FROM users
+        WHERE activated = false
+        AND deactivated_at IS NULL
+        AND created_at < NOW() - make_interval(secs => $2)
+        LIMIT $1
+    )`
+
+	return deleteInBatches(m.DB, query, batchSize, retention.Seconds())
+}
+
+// DeleteScheduled hard-deletes accounts whose deletion grace period is over. Tokens, permissions and everything
+// else referencing the user go with it through ON DELETE CASCADE. Audit events are the exception: the table is
+// append-only, so they are kept along with the IP addresses and user agents they recorded, see AuditEvent.
+func (m *UserModel) DeleteScheduled(batchSize int) (int64, error) {
+	query := `
+    DELETE FROM users
+    WHERE id IN (
+        SELECT id FROM users
+        WHERE deletion_scheduled_at < NOW()
+        LIMIT $1
+    )`
+
+	return deleteInBatches(m.DB, query, batchSize)
+}
@@ -0,0 +1,270 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrNoKeys       = errors.New("no signing keys loaded")
+)
+
+var b64 = base64.RawURLEncoding
+
+type Claims struct {
+	Subject     string   `json:"sub"`
+	Issuer      string   `json:"iss"`
+	IssuedAt    int64    `json:"iat"`
+	NotBefore   int64    `json:"nbf"`
+	Expiry      int64    `json:"exp"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type key struct {
+	id      string
+	alg     string
+	private crypto.Signer
+}
+
+// KeySet holds the private keys used to issue tokens. The first key signs new tokens, every key is accepted when
+// verifying so old keys can be kept around until the tokens they signed have expired.
+type KeySet struct {
+	issuer string
+	keys   []key
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func LoadKeySet(issuer string, paths ...string) (*KeySet, error) {
+	ks := &KeySet{issuer: issuer}
+	for _, path := range paths {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := parsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		err = ks.Add(signer)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(ks.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return ks, nil
+}
+
+func parsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// Add appends a key to the set. Only Ed25519 and RSA (2048 bits or more) keys are supported.
+func (ks *KeySet) Add(signer crypto.Signer) error {
+	k := key{private: signer}
+	switch priv := signer.(type) {
+	case ed25519.PrivateKey:
+		k.alg = AlgEdDSA
+	case *rsa.PrivateKey:
+		if priv.N.BitLen() < 2048 {
+			return errors.New("RSA keys must be at least 2048 bits long")
+		}
+		k.alg = AlgRS256
+	default:
+		return errors.New("unsupported private key type")
+	}
+	k.id = thumbprint(publicJWK(k))
+	ks.keys = append(ks.keys, k)
+	return nil
+}
+
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	if len(ks.keys) == 0 {
+		return "", ErrNoKeys
+	}
+	k := ks.keys[0]
+	claims.Issuer = ks.issuer
+
+	headerJSON, err := json.Marshal(header{Alg: k.alg, Typ: "JWT", Kid: k.id})
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+
+	var signature []byte
+	switch k.alg {
+	case AlgEdDSA:
+		signature, err = k.private.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	case AlgRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	headerJSON, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var hdr header
+	if err := json.Unmarshal(headerJSON, &hdr); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	k, found := ks.lookup(hdr.Kid)
+	// NOTE: the algorithm always comes from our key, never from the header, otherwise a token could pick a weaker one.
+	if !found || hdr.Alg != k.alg {
+		return nil, ErrInvalidToken
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch pub := k.private.Public().(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signingInput, signature) {
+			return nil, ErrInvalidToken
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signingInput)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != ks.issuer {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now().Unix()
+	if claims.NotBefore > now {
+		return nil, ErrInvalidToken
+	}
+	if claims.Expiry <= now {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func (ks *KeySet) lookup(kid string) (key, bool) {
+	for _, k := range ks.keys {
+		if k.id == kid {
+			return k, true
+		}
+	}
+	return key{}, false
+}
+
+// JWKS returns the public half of every key in the set, in the format served at /.well-known/jwks.json.
+func (ks *KeySet) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		jwk := publicJWK(k)
+		jwk.Kid = k.id
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+func publicJWK(k key) JWK {
+	jwk := JWK{Use: "sig", Alg: k.alg}
+	switch pub := k.private.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64.EncodeToString(pub)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(pub.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a key, which we use as its kid.
+func thumbprint(jwk JWK) string {
+	var members string
+	switch jwk.Kty {
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Crv, jwk.Kty, jwk.X)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64.EncodeToString(sum[:])
+}
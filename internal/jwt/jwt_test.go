@@ -0,0 +1,323 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIssuer = "greenlight.test"
+
+func newEd25519Key(t *testing.T) crypto.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func newRSAKey(t *testing.T, bits int) crypto.Signer {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func newKeySet(t *testing.T, issuer string, signers ...crypto.Signer) *KeySet {
+	t.Helper()
+	ks := &KeySet{issuer: issuer}
+	for _, signer := range signers {
+		err := ks.Add(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ks
+}
+
+func validClaims() Claims {
+	now := time.Now().Unix()
+	return Claims{
+		Subject:     "42",
+		IssuedAt:    now,
+		NotBefore:   now,
+		Expiry:      now + 60,
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+	}
+}
+
+func sign(t *testing.T, ks *KeySet, claims Claims) string {
+	t.Helper()
+	token, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// reencode replaces one part of token with the base64url encoding of v marshalled as JSON.
+func reencode(t *testing.T, token string, part int, v any) string {
+	t.Helper()
+	js, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	parts[part] = b64.EncodeToString(js)
+	return strings.Join(parts, ".")
+}
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		signer crypto.Signer
+		alg    string
+	}{
+		{"ed25519", newEd25519Key(t), AlgEdDSA},
+		{"rsa", newRSAKey(t, 2048), AlgRS256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := newKeySet(t, testIssuer, tt.signer)
+			want := validClaims()
+			token := sign(t, ks, want)
+
+			var hdr header
+			js, err := b64.DecodeString(strings.Split(token, ".")[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(js, &hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Alg != tt.alg || hdr.Typ != "JWT" || hdr.Kid != ks.keys[0].id {
+				t.Errorf("got header %+v, want alg %s, typ JWT and kid %s", hdr, tt.alg, ks.keys[0].id)
+			}
+
+			got, err := ks.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if got.Subject != want.Subject || got.Issuer != testIssuer || got.Expiry != want.Expiry || !got.Activated ||
+				len(got.Permissions) != 1 || got.Permissions[0] != "movies:read" {
+				t.Errorf("got claims %+v, want %+v issued by %s", got, want, testIssuer)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsAlgorithm(t *testing.T) {
+	edKeys := newKeySet(t, testIssuer, newEd25519Key(t))
+	rsaKeys := newKeySet(t, testIssuer, newRSAKey(t, 2048))
+
+	tests := []struct {
+		name string
+		ks   *KeySet
+		alg  string
+		sig  bool
+	}{
+		{"RS256 on an Ed25519 key", edKeys, AlgRS256, true},
+		{"EdDSA on an RSA key", rsaKeys, AlgEdDSA, true},
+		{"HS256", rsaKeys, "HS256", true},
+		{"none", edKeys, "none", false},
+		{"none with a signature", edKeys, "none", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := sign(t, tt.ks, validClaims())
+			token = reencode(t, token, 0, header{Alg: tt.alg, Typ: "JWT", Kid: tt.ks.keys[0].id})
+			if !tt.sig {
+				token = token[:strings.LastIndex(token, ".")+1]
+			}
+			_, err := tt.ks.Verify(token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsUnknownKid(t *testing.T) {
+	ks := newKeySet(t, testIssuer, newEd25519Key(t))
+	other := newKeySet(t, testIssuer, newEd25519Key(t))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"key of another set", sign(t, other, validClaims())},
+		{"made up kid", reencode(t, sign(t, ks, validClaims()), 0, header{Alg: AlgEdDSA, Typ: "JWT", Kid: "made-up"})},
+		{"no kid", reencode(t, sign(t, ks, validClaims()), 0, header{Alg: AlgEdDSA, Typ: "JWT"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ks.Verify(tt.token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	for _, signer := range []crypto.Signer{newEd25519Key(t), newRSAKey(t, 2048)} {
+		ks := newKeySet(t, testIssuer, signer)
+		token := sign(t, ks, validClaims())
+
+		claims := validClaims()
+		claims.Issuer = testIssuer
+		claims.Permissions = append(claims.Permissions, "movies:write")
+		payload := reencode(t, token, 1, claims)
+
+		parts := strings.Split(token, ".")
+		signature, err := b64.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature[0] ^= 0x01
+		parts[2] = b64.EncodeToString(signature)
+		sig := strings.Join(parts, ".")
+
+		tests := []struct {
+			name  string
+			token string
+		}{
+			{"payload", payload},
+			{"signature", sig},
+			{"missing signature", token[:strings.LastIndex(token, ".")]},
+			{"not base64", token + "!"},
+		}
+
+		for _, tt := range tests {
+			t.Run(ks.keys[0].alg+" "+tt.name, func(t *testing.T) {
+				_, err := ks.Verify(tt.token)
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyRejectsIssuer(t *testing.T) {
+	signer := newEd25519Key(t)
+	ks := newKeySet(t, testIssuer, signer)
+	other := newKeySet(t, "someone.else", signer)
+
+	_, err := ks.Verify(sign(t, other, validClaims()))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestVerifyTimes(t *testing.T) {
+	ks := newKeySet(t, testIssuer, newEd25519Key(t))
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		notBefore int64
+		expiry    int64
+		want      error
+	}{
+		{"valid", now, now + 60, nil},
+		{"not before in the past", now - 60, now + 60, nil},
+		{"not before in the future", now + 60, now + 120, ErrInvalidToken},
+		{"expires now", now - 60, now, ErrExpiredToken},
+		{"expired", now - 120, now - 60, ErrExpiredToken},
+		{"no expiry", now, 0, ErrExpiredToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			claims.NotBefore, claims.Expiry = tt.notBefore, tt.expiry
+			_, err := ks.Verify(sign(t, ks, claims))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRotation(t *testing.T) {
+	oldKey := newRSAKey(t, 2048)
+	newKey := newEd25519Key(t)
+	before := newKeySet(t, testIssuer, oldKey)
+	after := newKeySet(t, testIssuer, newKey, oldKey)
+
+	token := sign(t, before, validClaims())
+	_, err := after.Verify(token)
+	if err != nil {
+		t.Errorf("token signed by the second key: %v", err)
+	}
+
+	// New tokens are signed with the first key only.
+	token = sign(t, after, validClaims())
+	_, err = before.Verify(token)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+	}
+	if len(after.JWKS()) != 2 {
+		t.Errorf("got %d keys in the JWKS, want 2", len(after.JWKS()))
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+		want string
+	}{
+		{
+			// RFC 7638 section 3.1.
+			"rsa",
+			JWK{
+				Kty: "RSA",
+				N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				E:   "AQAB",
+				Alg: AlgRS256,
+				Kid: "2011-04-29",
+			},
+			"NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			// RFC 8037 appendix A.3.
+			"ed25519",
+			JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+			"kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thumbprint(tt.jwk); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddRejectsShortRSAKeys(t *testing.T) {
+	ks := &KeySet{issuer: testIssuer}
+	err := ks.Add(newRSAKey(t, 1024))
+	if err == nil {
+		t.Fatal("1024 bit RSA key accepted")
+	}
+	if len(ks.keys) != 0 {
+		t.Errorf("got %d keys in the set, want 0", len(ks.keys))
+	}
+}
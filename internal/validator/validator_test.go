@@ -0,0 +1,32 @@
+package validator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     float64
+	}{
+		{"empty", "", 0},
+		{"lowercase", "abcdefgh", 8 * math.Log2(26)},
+		{"repeated character", "aaaaaaaa", math.Log2(26)},
+		{"runs count once", "aabbccdd", 4 * math.Log2(26)},
+		{"letters and digits", "Abcdefg1", 8 * math.Log2(62)},
+		{"symbols", "ab!?", 4 * math.Log2(59)},
+		{"every ascii class", "aB3$", 4 * math.Log2(95)},
+		{"non ascii", "héllo", 4 * math.Log2(126)},
+		{"passphrase", "purple monkey dishwasher", 24 * math.Log2(59)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Entropy(tt.password); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Entropy(%q) = %.2f, want %.2f", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+DROP INDEX IF EXISTS audit_events_action_idx;
+DROP INDEX IF EXISTS audit_events_actor_id_idx;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS diff;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_created_by_fkey;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
+DELETE FROM users WHERE email = 'system@greenlight.internal';
@@ -0,0 +1,23 @@
+-- Movies created before ownership existed belong to a system account nobody can sign in to: it has no password and
+-- is deactivated, which also keeps it out of the unactivated users purge.
+INSERT INTO users (name, email, password_hash, activated, deactivated_at)
+VALUES ('Greenlight', 'system@greenlight.internal', '', false, NOW())
+ON CONFLICT (email) DO NOTHING;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint;
+UPDATE movies SET created_by = (SELECT id FROM users WHERE email = 'system@greenlight.internal') WHERE created_by IS NULL;
+
+-- Movies of deleted users fall back to the system account.
+DO $$
+BEGIN
+    EXECUTE format('ALTER TABLE movies ALTER COLUMN created_by SET DEFAULT %s',
+        (SELECT id FROM users WHERE email = 'system@greenlight.internal'));
+END
+$$;
+
+ALTER TABLE movies ALTER COLUMN created_by SET NOT NULL;
+ALTER TABLE movies ADD CONSTRAINT movies_created_by_fkey FOREIGN KEY (created_by) REFERENCES users ON DELETE SET DEFAULT;
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+INSERT INTO permissions (code)
+VALUES ('movies:admin');
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug citext UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS memberships_user_id_idx ON memberships (user_id);
+
+-- The catalog we had so far becomes the default organization, everybody keeps the access they had.
+INSERT INTO organizations (name, slug)
+VALUES ('Greenlight', 'default');
+
+INSERT INTO memberships (organization_id, user_id, role)
+SELECT (SELECT id FROM organizations WHERE slug = 'default'), users.id,
+    CASE WHEN EXISTS (
+        SELECT 1 FROM users_permissions
+        INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+        WHERE users_permissions.user_id = users.id AND permissions.code = 'movies:write'
+    ) THEN 'editor' ELSE 'viewer' END
+FROM users
+WHERE users.email <> 'system@greenlight.internal';
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+UPDATE movies SET organization_id = (SELECT id FROM organizations WHERE slug = 'default') WHERE organization_id IS NULL;
+ALTER TABLE movies ALTER COLUMN organization_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
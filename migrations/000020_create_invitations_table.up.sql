@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    hash bytea UNIQUE NOT NULL,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    email citext NOT NULL,
+    role text NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    invited_by bigint REFERENCES users ON DELETE SET NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS invitations_organization_id_idx ON invitations (organization_id);
@@ -0,0 +1,7 @@
+UPDATE memberships SET role =
+    CASE WHEN EXISTS (
+        SELECT 1 FROM users_permissions
+        INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+        WHERE users_permissions.user_id = memberships.user_id AND permissions.code = 'movies:write'
+    ) THEN 'editor' ELSE 'viewer' END
+WHERE organization_id = (SELECT id FROM organizations WHERE slug = 'default') AND role = 'owner';
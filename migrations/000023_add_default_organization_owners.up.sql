@@ -0,0 +1,8 @@
+-- The default organization was created without an owner, leaving nobody able to change roles in it. User
+-- administrators become its owners.
+INSERT INTO memberships (organization_id, user_id, role)
+SELECT (SELECT id FROM organizations WHERE slug = 'default'), users_permissions.user_id, 'owner'
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+WHERE permissions.code = 'users:admin'
+ON CONFLICT (organization_id, user_id) DO UPDATE SET role = 'owner';